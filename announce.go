@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	dgo "github.com/bwmarrin/discordgo"
+)
+
+const (
+	EVENT_TRACK_START    = "track-start"
+	EVENT_TRACK_FAILED   = "track-failed"
+	EVENT_QUEUE_FINISHED = "queue-finished"
+	EVENT_IDLE_LEAVE     = "idle-leave"
+	EVENT_RECONNECT      = "reconnect"
+)
+
+// Every event that can be announced, with whether it is by default. Track
+// starts are off as the now playing message shows them already.
+var AnnounceEvents = []struct {
+	Name    string
+	Default bool
+}{
+	{EVENT_TRACK_START, false},
+	{EVENT_TRACK_FAILED, true},
+	{EVENT_QUEUE_FINISHED, false},
+	{EVENT_IDLE_LEAVE, true},
+	{EVENT_RECONNECT, true},
+}
+
+// Whether event gets announced in the guild
+func (gs GuildSettings) Announces(event string) bool {
+	if enabled, ok := gs.Announcements[event]; ok {
+		return enabled
+	}
+	for _, e := range AnnounceEvents {
+		if e.Name == event {
+			return e.Default
+		}
+	}
+	return false
+}
+
+// Sends msg to the announce channel of guildId, if event is announced there
+func (c *Client) Announce(s *dgo.Session, guildId, event, msg string) {
+	if !c.Settings.Get(guildId).Announces(event) {
+		return
+	}
+
+	channelId := c.AnnounceChannel(guildId)
+	if channelId == "" {
+		return
+	}
+	if _, err := s.ChannelMessageSend(channelId, msg); err != nil {
+		log.Printf("Failed announcing %s to channel %s in guild %s: %v\n", event, channelId, guildId, err)
+	}
+}
+
+// Announces event of p without waiting, nothing happens until the client
+// hooked the playback up.
+func (p *Playback) announce(event, msg string) {
+	if p.announcer != nil {
+		go p.announcer(event, msg)
+	}
+}
+
+func (c *Client) describeAnnouncements(guildId string) string {
+	settings := c.Settings.Get(guildId)
+
+	var sb strings.Builder
+	channel := "the channel the bot was last used from"
+	if settings.AnnounceChannelID != "" {
+		channel = "<#" + settings.AnnounceChannelID + ">"
+	}
+	sb.WriteString(fmt.Sprintf("Announcing in %s\n", channel))
+	for _, event := range AnnounceEvents {
+		state := "off"
+		if settings.Announces(event.Name) {
+			state = "on"
+		}
+		sb.WriteString(fmt.Sprintf("%s: %s\n", event.Name, state))
+	}
+	return sb.String()
+}
+
+func (c *Client) configAnnounce(s *dgo.Session, i *dgo.InteractionCreate, subcommand string, options CommandOptions) {
+	var change func(*GuildSettings)
+	switch subcommand {
+	case "show":
+		InteractionSilentUpdate(s, i, c.describeAnnouncements(i.GuildID))
+		return
+	case "channel":
+		channelId := options.ID("channel")
+		change = func(settings *GuildSettings) {
+			settings.AnnounceChannelID = channelId
+		}
+	case "event":
+		event := options.String("event", "")
+		enabled := options.Bool("enabled", true)
+		change = func(settings *GuildSettings) {
+			announcements := make(map[string]bool, len(settings.Announcements)+1)
+			for name, on := range settings.Announcements {
+				announcements[name] = on
+			}
+			announcements[event] = enabled
+			settings.Announcements = announcements
+		}
+	default:
+		InteractionErrorUpdate(s, i, BAD_COMMAND_ARG_ERR)
+		return
+	}
+
+	if err := c.Settings.Update(i.GuildID, change); err != nil {
+		log.Printf("[STORE_ERR]: failed saving settings of guild %s: %v\n", i.GuildID, err)
+		InteractionErrorUpdate(s, i, NO_PLAYER_AVAILABLE_ERR)
+		return
+	}
+	InteractionSilentUpdate(s, i, c.describeAnnouncements(i.GuildID))
+}
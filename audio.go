@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"path"
 	"strings"
 
 	"ndmb/enc"
@@ -14,6 +17,19 @@ import (
 
 var FfmpegPath string = ""
 
+const MAX_ATTACHMENT_BYTES = 100 * 1024 * 1024
+
+const (
+	TRACK_SOURCE_YOUTUBE    = "youtube"
+	TRACK_SOURCE_PODCAST    = "podcast"
+	TRACK_SOURCE_ATTACHMENT = "attachment"
+	TRACK_SOURCE_URL        = "url" // Any other http stream
+)
+
+var TrackSources = []string{TRACK_SOURCE_YOUTUBE, TRACK_SOURCE_PODCAST, TRACK_SOURCE_ATTACHMENT, TRACK_SOURCE_URL}
+
+var attachmentExtensions = []string{".mp3", ".ogg", ".flac", ".mp4"}
+
 func SetFfmpegPath(path string) {
 	FfmpegPath = path
 }
@@ -22,19 +38,44 @@ func GetFfmpegPath() string {
 	return FfmpegPath
 }
 
-func ResolveAudioSource(input string) (Track, error) {
+// Searches youtube for query, giving up once ctx is done. searchtube takes no
+// context, a search outliving ctx keeps running in the background until its
+// request ends.
+func searchYoutube(ctx context.Context, query string) ([]*searchtube.SearchResult, error) {
+	type searchResult struct {
+		results []*searchtube.SearchResult
+		err     error
+	}
+
+	done := make(chan searchResult, 1)
+	go func() {
+		results, err := searchtube.Search(query, 1)
+		done <- searchResult{results, err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.results, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func ResolveAudioSource(ctx context.Context, input string) (Track, error) {
 	webUrl := ""
 	track := Track{}
 
 	if IsYoutubeUrl(input) {
-		mediaUrl, err := YoutubeMediaUrl(input)
+		media, err := YoutubeMediaInfo(ctx, input)
 		if err != nil {
 			return track, err
 		}
 
 		track.WebURL = input
-		track.MediaURL = mediaUrl
-		track.Title, err = ResolveVideoTitle(input)
+		track.MediaURL = media.MediaURL
+		track.Duration = media.Duration
+		track.Uploader = media.Uploader
+		track.Title, err = ResolveVideoTitle(ctx, input)
 
 		if err != nil {
 			return track, err
@@ -52,7 +93,7 @@ func ResolveAudioSource(input string) (Track, error) {
 	}
 
 	results := []*searchtube.SearchResult{}
-	searchResults, err := searchtube.Search(input, 1)
+	searchResults, err := searchYoutube(ctx, input)
 	if err != nil {
 		return track, err
 	}
@@ -63,16 +104,21 @@ func ResolveAudioSource(input string) (Track, error) {
 		}
 	}
 
+	if len(results) == 0 {
+		return track, fmt.Errorf("no results found for %s", input)
+	}
 	webUrl = results[0].URL
 
-	mediaUrl, err := YoutubeMediaUrl(webUrl)
+	media, err := YoutubeMediaInfo(ctx, webUrl)
 	if err != nil {
 		return track, err
 	}
 
-	track.MediaURL = mediaUrl
+	track.MediaURL = media.MediaURL
+	track.Duration = media.Duration
+	track.Uploader = media.Uploader
 	track.WebURL = webUrl
-	track.Title, err = ResolveVideoTitle(webUrl)
+	track.Title, err = ResolveVideoTitle(ctx, webUrl)
 	if err != nil {
 		return track, err
 	}
@@ -80,23 +126,125 @@ func ResolveAudioSource(input string) (Track, error) {
 	return track, nil
 }
 
-func FetchHttpMediaStream(mediaUrl string) (io.ReadCloser, int64, error) {
+func FetchHttpMediaStream(mediaUrl string) (io.ReadCloser, int64, string, error) {
 	req, err := http.NewRequest("GET", mediaUrl, nil)
 	if err != nil {
 		log.Println("Error preparing media stream request:", err)
-		return nil, 0, err
+		return nil, 0, "", err
 	}
 
 	client := http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Println("Error sending media stream request:", err)
-		return nil, 0, err
+		return nil, 0, "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err := fmt.Errorf("media stream request gave status code: %d", resp.StatusCode)
+		log.Println("Error sending media stream request:", err)
+		return nil, 0, "", err
+	}
+
+	return resp.Body, resp.ContentLength, resp.Header.Get("Content-Type"), nil
+}
+
+// Fills in the media url of tracks kept without one, which get resolved
+// only right before being played.
+func ResolveLazyTrack(track Track) (Track, error) {
+	if track.MediaURL != "" {
+		return track, nil
+	}
+
+	resolved, err := DefaultResolver.Resolve(track.WebURL)
+	if err != nil {
+		return track, err
+	}
+
+	track.MediaURL = resolved.MediaURL
+	if track.Title == "" {
+		track.Title = resolved.Title
+	}
+	if track.Duration == 0 {
+		track.Duration = resolved.Duration
+	}
+	if track.Uploader == "" {
+		track.Uploader = resolved.Uploader
+	}
+	return track, nil
+}
+
+// Resolves track again when its media url is likely to have expired, as
+// youtube ones do after a few hours, keeping everything else about it.
+func RefreshTrack(track Track) (Track, error) {
+	return ResolveLazyTrack(track.Unresolved())
+}
+
+// Whether mediaUrl points to a file uploaded to discord
+func IsAttachmentUrl(mediaUrl string) bool {
+	return strings.HasPrefix(mediaUrl, "https://cdn.discordapp.com/attachments/") ||
+		strings.HasPrefix(mediaUrl, "https://media.discordapp.net/attachments/")
+}
+
+// Builds a track out of a file uploaded to discord, refusing anything that
+// doesn't look like a supported audio or video file.
+func ResolveAttachment(attachment *dgo.MessageAttachment) (Track, error) {
+	track := Track{}
+
+	ext := strings.ToLower(path.Ext(attachment.Filename))
+	supported := false
+	for _, e := range attachmentExtensions {
+		if ext == e {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return track, fmt.Errorf("unsupported attachment extension: %s", ext)
+	}
+
+	if attachment.Size > MAX_ATTACHMENT_BYTES {
+		return track, fmt.Errorf("attachment too large: %d bytes", attachment.Size)
+	}
+
+	if err := ValidateHttpMedia(attachment.URL, MAX_ATTACHMENT_BYTES); err != nil {
+		return track, err
+	}
+
+	track.Title = attachment.Filename
+	track.WebURL = attachment.URL
+	track.MediaURL = attachment.URL
+	return track, nil
+}
+
+// Opens mediaUrl and makes sure it serves audio or video no bigger than
+// maxBytes, so that ffmpeg doesn't get fed with anything else.
+func ValidateHttpMedia(mediaUrl string, maxBytes int64) error {
+	body, contentLength, contentType, err := FetchHttpMediaStream(mediaUrl)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	if !strings.HasPrefix(mediaType, "audio/") &&
+		!strings.HasPrefix(mediaType, "video/") &&
+		mediaType != "application/ogg" {
+		return fmt.Errorf("unsupported media content type: %s", contentType)
+	}
+
+	if contentLength > maxBytes {
+		return fmt.Errorf("media too large: %d bytes", contentLength)
 	}
 
-	return resp.Body, resp.ContentLength, nil
+	return nil
 }
 
-func PlayMediaInVoiceChannel(mediaUrl string, player *enc.Enc, voiceConnection *dgo.VoiceConnection, errCh chan error, cmdCh chan enc.Command, respCh chan enc.Response) {
-	go player.GetOpusFrames(mediaUrl, enc.DefaultOptions(FfmpegPath), voiceConnection.OpusSend, errCh, cmdCh, respCh)
+// Streams track at volume percent into the voice connection
+func PlayMediaInVoiceChannel(track Track, volume int, player *enc.Enc, voiceConnection *dgo.VoiceConnection, errCh chan error, cmdCh chan enc.Command, respCh chan enc.Response) {
+	opts := enc.DefaultOptions(FfmpegPath)
+	opts.Seek = track.Seek
+	opts.Volume = float32(volume) / 100
+	go player.GetOpusFrames(track.MediaURL, opts, voiceConnection.OpusSend, errCh, cmdCh, respCh)
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Pauloo27/searchtube"
+	dgo "github.com/bwmarrin/discordgo"
+)
+
+const (
+	AUTOPLAY_CANDIDATES = 25
+	AUTOPLAY_TIMEOUT    = 30 * time.Second
+)
+
+// Identifies a track so that the same youtube video matches whatever its url
+func autoplayKey(track Track) string {
+	if id := YoutubeVideoID(track.WebURL); id != "" {
+		return id
+	}
+	return track.SourceKey()
+}
+
+// Picks a track related to last that wasn't played recently, first out of
+// the youtube mix of last, then out of a search on its uploader and title.
+func (p *Playback) RelatedTrack(last Track) (Track, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), AUTOPLAY_TIMEOUT)
+	defer cancel()
+
+	played := map[string]bool{autoplayKey(last): true}
+	for _, entry := range p.History.Recent(HISTORY_SIZE) {
+		played[autoplayKey(entry.Track)] = true
+	}
+	for _, queued := range p.Queue.Tracks() {
+		played[autoplayKey(queued)] = true
+	}
+
+	if IsYoutubeUrl(last.WebURL) {
+		entries, err := YoutubeMix(ctx, last.WebURL, AUTOPLAY_CANDIDATES)
+		if err != nil {
+			log.Printf("[AUTOPLAY_ERR]: failed listing mix of %s: %v\n", last.WebURL, err)
+		}
+		for _, entry := range entries {
+			if !played[entry.ID] {
+				return Track{
+					Title:      entry.Title,
+					WebURL:     YoutubeWatchUrl(entry.ID),
+					Duration:   int(entry.Duration),
+					Uploader:   entry.Uploader,
+					Autoplayed: true,
+				}, true
+			}
+		}
+	}
+
+	query := strings.TrimSpace(last.Uploader + " " + last.Title)
+	if query == "" {
+		return Track{}, false
+	}
+
+	results, err := searchtube.Search(query, AUTOPLAY_CANDIDATES)
+	if err != nil {
+		log.Printf("[AUTOPLAY_ERR]: failed searching %s: %v\n", query, err)
+		return Track{}, false
+	}
+	for _, result := range results {
+		if result.Live || played[result.ID] {
+			continue
+		}
+
+		duration, _ := result.GetDuration()
+		return Track{
+			Title:      result.Title,
+			WebURL:     YoutubeWatchUrl(result.ID),
+			Duration:   int(duration.Seconds()),
+			Uploader:   result.Uploader,
+			Autoplayed: true,
+		}, true
+	}
+	return Track{}, false
+}
+
+func (c *Client) AutoplayCommand(s *dgo.Session, i *dgo.InteractionCreate) {
+	playback, ok := c.deferredPlayback(s, i)
+	if !ok {
+		return
+	}
+
+	enabled := InteractionOptions(i).Bool("enabled", !playback.Autoplay)
+
+	playback.Autoplay = enabled
+	playback.queueChanged()
+	if enabled {
+		InteractionMessageUpdate(s, i, "Autoplay enabled, related tracks will keep playing once the queue is over")
+		return
+	}
+	InteractionMessageUpdate(s, i, "Autoplay disabled")
+}
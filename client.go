@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"ndmb/enc"
@@ -11,24 +13,93 @@ import (
 )
 
 type Track struct {
-	Title    string
-	WebURL   string
-	MediaURL string
+	Title       string
+	WebURL      string
+	MediaURL    string
+	Seek        float32        // Position in seconds the track starts playing from
+	Episode     *PodcastListen // Set when the track is a podcast episode
+	Duration    int            // Seconds, 0 if unknown
+	Requester   string         // Display name of the requesting user
+	RequesterID string
+	Uploader    string
+	Autoplayed  bool // Whether autoplay picked the track rather than someone
+}
+
+// A copy of the track without its media url, if it can be resolved again
+// later from its web url.
+func (t Track) Unresolved() Track {
+	if IsYoutubeUrl(t.WebURL) {
+		t.MediaURL = ""
+	}
+	return t
+}
+
+// The kind of source the track comes from, one of TRACK_SOURCE_*
+func (t Track) Source() string {
+	switch {
+	case t.Episode != nil:
+		return TRACK_SOURCE_PODCAST
+	case IsYoutubeUrl(t.WebURL):
+		return TRACK_SOURCE_YOUTUBE
+	case IsAttachmentUrl(t.WebURL):
+		return TRACK_SOURCE_ATTACHMENT
+	}
+	return TRACK_SOURCE_URL
+}
+
+// Identifies where a track comes from, regardless of how it was requested
+func (t Track) SourceKey() string {
+	if strings.HasPrefix(t.WebURL, "http") {
+		return t.WebURL
+	}
+	return t.MediaURL
 }
 
 type Playback struct {
 	Track
+	GuildID         string
 	Player          *enc.Enc
-	Queue           []Track
+	Queue           *Queue
+	Loop            LoopMode
+	Autoplay        bool // Whether related tracks play once the queue is over
+	Volume          int  // Percent, applies from the next track on
+	Language        string
+	History         *History
 	CommandChannel  chan enc.Command
 	ResponseChannel chan enc.Response
 	ErrorChannel    chan error
 	voiceConnection *dgo.VoiceConnection
+	speechLock      sync.Mutex
+	queueListeners  []func(*Playback)
+	queueView       *QueueView
+	queueViewLock   sync.Mutex
+	stopped         bool // Whether the current track got stopped before its end
+	halted          bool // Whether it got stopped for good, nothing plays after it
+	skipVotes       SkipVotes
+	skipVotesLock   sync.Mutex
+	nowPlaying      *NowPlayingView
+	nowPlayingLock  sync.Mutex
+	listeners       int  // Listeners in the voice channel of the bot
+	autoPaused      bool // Whether the pause is due to everyone leaving
+	reconnecting    bool // Whether the dropped voice connection is being rejoined
+	voiceLock       sync.Mutex
+	queryLock       sync.Mutex // Held while waiting for the player to answer
+	announcer       func(event, msg string)
 }
 
 type Client struct {
-	Players        map[string]*Playback
-	ActiveChannels map[string]string
+	Podcasts    *PodcastLibrary
+	Store       *Store // Nil when persistence is disabled
+	Permissions *Permissions
+	Settings    *Settings
+	session     *dgo.Session
+	allowed     map[string]bool // Guilds the bot operates in, empty allows any
+
+	mu             sync.RWMutex
+	players        map[string]*Playback
+	activeChannels map[string]string
+	guilds         map[string]bool // Guilds the bot is currently a member of
+	shuttingDown   bool
 }
 
 const (
@@ -40,39 +111,274 @@ const (
 	BAD_COMMAND_ARG_ERR     = "Make sure to provide a valid command argument"
 	SEEK_TOO_FAR_ERR        = "You went too far (the encoder might still be buffering)"
 	VOICE_IDLE_ERR          = "Failed disconnecting from idle channel connection"
-	MAX_IDLE_SECONDS        = 300
 )
 
-func NewClient(s *dgo.Session, guildIds []string) Client {
-	c := Client{
-		Players:        make(map[string]*Playback),
-		ActiveChannels: make(map[string]string, len(guildIds)),
+// Creates a client operating in allowedGuildIds, or in every guild it's a
+// member of when none are given. Playbacks get created on first use.
+func NewClient(s *dgo.Session, allowedGuildIds []string, store *Store) *Client {
+	c := &Client{
+		Podcasts:       NewPodcastLibrary(),
+		Store:          store,
+		Permissions:    NewPermissions(store),
+		Settings:       NewSettings(store),
+		session:        s,
+		allowed:        make(map[string]bool, len(allowedGuildIds)),
+		players:        make(map[string]*Playback),
+		activeChannels: make(map[string]string),
+		guilds:         make(map[string]bool),
 	}
 
-	for _, gId := range guildIds {
-		c.ActiveChannels[gId] = ""
+	for _, gId := range allowedGuildIds {
+		c.allowed[gId] = true
+	}
 
-		p := &Playback{
-			CommandChannel:  make(chan enc.Command),
-			ResponseChannel: make(chan enc.Response),
-			ErrorChannel:    make(chan error),
-			Queue:           make([]Track, 0),
-			Player:          enc.NewEnc(enc.DefaultOptions(GetFfmpegPath())),
-		}
+	return c
+}
+
+func (c *Client) newPlayback(gId string) *Playback {
+	s := c.session
+	p := &Playback{
+		GuildID:         gId,
+		CommandChannel:  make(chan enc.Command),
+		ResponseChannel: make(chan enc.Response),
+		ErrorChannel:    make(chan error),
+		Queue:           NewQueue(),
+		History:         NewHistory(HISTORY_SIZE),
+		Player:          enc.NewEnc(enc.DefaultOptions(GetFfmpegPath())),
+	}
+	p.resetSkipVotes()
+	p.announcer = func(event, msg string) {
+		c.Announce(s, gId, event, msg)
+	}
+
+	p.Player.Listen(enc.PlayerEventStopped, func(event enc.PlayerEvent) {
+		p.stopped = true
+	})
 
-		// Whenever a track ends, play the next one
-		p.Player.Listen(enc.PlayerEventTrackEnded, func(event enc.PlayerEvent) {
-			if len(p.Queue) > 0 && p.voiceConnection != nil {
-				nextTrack := p.Queue[0]
-				p.Queue = p.Queue[1:]
-				PlayMediaInVoiceChannel(nextTrack.MediaURL, p.Player, p.voiceConnection, p.ErrorChannel, p.CommandChannel, p.ResponseChannel)
+	// Whenever a track ends, play the next one
+	p.Player.Listen(enc.PlayerEventTrackEnded, func(event enc.PlayerEvent) {
+		stopped, halted := p.stopped, p.halted
+		p.stopped, p.halted = false, false
+		p.History.Finish()
+		if p.VoiceConnection() == nil || halted {
+			return
+		}
+		if nextTrack, ok := p.nextAfter(p.Track, stopped); ok {
+			p.Play(nextTrack)
+			return
+		}
+		if stopped {
+			return
+		}
+		if p.Autoplay {
+			if related, ok := p.RelatedTrack(p.Track); ok {
+				p.Play(related)
+				return
 			}
+		}
+		p.announce(EVENT_QUEUE_FINISHED, "The queue is over, use /play to add more tracks")
+	})
+
+	p.Queue.Subscribe(func(event QueueEvent) {
+		p.queueChanged()
+	})
+
+	p.OnQueueChange(func(p *Playback) {
+		go c.RefreshQueueView(s, p)
+		go c.RefreshNowPlaying(s, p)
+		if !c.ShuttingDown() {
+			go c.SavePlayback(p)
+		}
+	})
+
+	// Whatever happens to the track, it's no longer automatically paused
+	for _, event := range []enc.PlayerEvent{enc.PlayerEventResumed, enc.PlayerEventTrackEnded} {
+		p.Player.Listen(event, func(event enc.PlayerEvent) {
+			p.voiceLock.Lock()
+			p.autoPaused = false
+			p.voiceLock.Unlock()
 		})
+	}
 
-		c.Players[gId] = p
+	for _, event := range []enc.PlayerEvent{enc.PlayerEventPaused, enc.PlayerEventResumed, enc.PlayerEventTrackEnded} {
+		p.Player.Listen(event, func(event enc.PlayerEvent) {
+			go c.RefreshNowPlaying(s, p)
+		})
 	}
 
-	return c
+	return p
+}
+
+// Makes track the current one and starts streaming it into the voice connection
+func (p *Playback) Play(track Track) {
+	track, err := ResolveLazyTrack(track)
+	if err != nil {
+		log.Printf("Failed resolving %s in guild %s, skipping it: %v\n", track.WebURL, p.GuildID, err)
+		p.announce(EVENT_TRACK_FAILED, fmt.Sprintf("Couldn't play %s | %s, skipping it", track.Title, track.WebURL))
+		if next, ok := p.Queue.Pop(); ok {
+			p.Play(next)
+		}
+		return
+	}
+
+	p.Track = track
+	p.resetSkipVotes()
+	p.History.Record(track)
+	p.announce(EVENT_TRACK_START, p.NowPlayingMessage(track))
+
+	if !AnnounceTracks {
+		p.stream(track)
+		return
+	}
+
+	// Play runs on the player goroutine when a track ends, which must not wait
+	// for the speech. The track starts once its title got spoken.
+	go func() {
+		if err := p.Say("Now playing " + track.Title); err != nil {
+			log.Println("[TTS_ERR]:", err)
+		}
+		p.stream(track)
+	}()
+}
+
+// Starts streaming track into the voice connection, if there's still one
+func (p *Playback) stream(track Track) {
+	voiceConnection := p.VoiceConnection()
+	if voiceConnection == nil {
+		return
+	}
+	PlayMediaInVoiceChannel(track, p.Volume, p.Player,
+		voiceConnection,
+		p.ErrorChannel,
+		p.CommandChannel,
+		p.ResponseChannel)
+	p.queueChanged()
+}
+
+// Registers action to be called whenever the queue or the current track change
+func (p *Playback) OnQueueChange(action func(*Playback)) {
+	p.queueListeners = append(p.queueListeners, action)
+}
+
+func (p *Playback) queueChanged() {
+	for _, action := range p.queueListeners {
+		action(p)
+	}
+}
+
+// Stops the current track without playing anything after it, the queue is
+// left as it is
+func (p *Playback) Stop() {
+	if p.Player.State == enc.PlayerStatePlaying || p.Player.State == enc.PlayerStatePaused {
+		p.halted = true
+		p.CommandChannel <- enc.CommandStop{}
+	}
+}
+
+// Stops the current track so that the next one in queue starts playing, or
+// plays it straight away if the player is idle.
+func (p *Playback) Skip() (Track, bool) {
+	next, ok := p.Queue.Peek()
+	if !ok {
+		return Track{}, false
+	}
+
+	if p.Player.State == enc.PlayerStatePlaying || p.Player.State == enc.PlayerStatePaused {
+		// The track ended listener takes care of playing the next track
+		p.CommandChannel <- enc.CommandStop{}
+		return next, true
+	}
+
+	if next, ok = p.Queue.Pop(); ok {
+		p.Play(next)
+	}
+	return next, ok
+}
+
+// Plays track right away if nothing is being played, otherwise it gets
+// enqueued within the requester limits. Returns whether the track started
+// playing.
+func (p *Playback) PlayOrEnqueue(track Track) (bool, error) {
+	if p.Player.State == enc.PlayerStatePlaying || p.Player.State == enc.PlayerStatePaused {
+		return false, p.Queue.Enqueue(track)
+	}
+	if err := p.Queue.CheckSources(track); err != nil {
+		return false, err
+	}
+
+	p.Play(track)
+	return true, nil
+}
+
+// Position in seconds of the current track, counting its initial seek too.
+// Reports false if nothing is being played or the player didn't answer.
+func (p *Playback) Position() (float32, bool) {
+	if p.Player.State != enc.PlayerStatePlaying && p.Player.State != enc.PlayerStatePaused {
+		return 0, false
+	}
+
+	resp, ok := p.query(enc.CommandGetPlaybackTime{})
+	if !ok {
+		return 0, false
+	}
+	playbackTime, ok := resp.(enc.ResponsePlaybackTime)
+	return p.Seek + float32(playbackTime), ok
+}
+
+// Sends cmd to the player, reporting false if it didn't take the command in
+// time, e.g. because it's busy ending the track
+func (p *Playback) send(cmd enc.Command) bool {
+	select {
+	case p.CommandChannel <- cmd:
+		return true
+	case <-time.After(time.Second):
+		return false
+	}
+}
+
+// Sends cmd to the player and returns its answer, reporting false if the
+// player didn't take the command. Answers come back on a channel shared by
+// every caller, so queries are serialized.
+func (p *Playback) query(cmd enc.Command) (enc.Response, bool) {
+	p.queryLock.Lock()
+	defer p.queryLock.Unlock()
+
+	select {
+	case p.CommandChannel <- cmd:
+	case <-time.After(time.Second):
+		return nil, false
+	}
+
+	// The player answers right after taking the command and can't do anything
+	// else until the answer is received
+	return <-p.ResponseChannel, true
+}
+
+// Joins the voice channel the user is currently connected to, falling back to
+// the voice connection the bot already has in the guild.
+func JoinUserVoiceChannel(s *dgo.Session, guildId, userId string) (*dgo.VoiceConnection, error) {
+	g, err := s.State.Guild(guildId)
+	if err != nil {
+		return nil, err
+	}
+
+	voiceChannelId := ""
+	for _, vs := range g.VoiceStates {
+		if vs.UserID == userId {
+			voiceChannelId = vs.ChannelID
+			break
+		}
+	}
+
+	voiceConnection, err := s.ChannelVoiceJoin(guildId, voiceChannelId, false, true)
+	if err != nil {
+		if vc, ok := s.VoiceConnections[guildId]; ok {
+			return vc, nil
+		}
+		return nil, err
+	}
+
+	return voiceConnection, nil
 }
 
 func (c *Client) ClientPlaybacksLogger(logInterval int) chan struct{} {
@@ -86,12 +392,13 @@ func (c *Client) ClientPlaybacksLogger(logInterval int) chan struct{} {
 			default:
 			}
 
-			for _, player := range c.Players {
-				if player.voiceConnection == nil {
+			for _, player := range c.Playbacks() {
+				voiceConnection := player.VoiceConnection()
+				if voiceConnection == nil {
 					continue
 				}
 
-				guildId := player.voiceConnection.GuildID
+				guildId := voiceConnection.GuildID
 				select {
 				case err := <-player.ErrorChannel:
 					log.Printf("[PLAYER_ERR]: %v at guildId: %s\n", err, guildId)
@@ -134,18 +441,19 @@ func (c *Client) StartDisconnectionTimmer(s *dgo.Session, tickEvery int) chan st
 
 	go func() {
 		tickDuration := time.Duration(int64(time.Second) * int64(tickEvery))
-		timers := make(map[string]int, len(c.Players))
-		for guild := range c.Players {
-			timers[guild] = 0
-		}
+		timers := make(map[string]int)
 
 		for {
 			time.Sleep(tickDuration)
 
 			select {
 			case <-stop:
-				for guild := range c.Players {
-					if err := s.VoiceConnections[guild].Disconnect(); err != nil {
+				for _, player := range c.Playbacks() {
+					voiceConnection := player.VoiceConnection()
+					if voiceConnection == nil {
+						continue
+					}
+					if err := voiceConnection.Disconnect(); err != nil {
 						log.Println("[VOICE_IDLE_ERR]:", VOICE_IDLE_ERR)
 						continue
 					}
@@ -154,19 +462,15 @@ func (c *Client) StartDisconnectionTimmer(s *dgo.Session, tickEvery int) chan st
 			default:
 			}
 
-			for guildId, player := range c.Players {
+			for _, player := range c.Playbacks() {
+				guildId := player.GuildID
 				voiceConnection := s.VoiceConnections[guildId]
 				if voiceConnection == nil {
 					continue
 				}
 
-				guild, err := s.State.Guild(guildId)
-				if err != nil {
-					log.Println(err)
-				}
-
-				// tick even tho the call above errored
-				shouldTick := player.Player.State == enc.PlayerStateIdle || len(guild.VoiceStates) == 0
+				alone := player.Listeners() == 0
+				shouldTick := player.Player.State == enc.PlayerStateIdle || alone
 
 				if shouldTick {
 					timers[guildId] += tickEvery
@@ -174,18 +478,11 @@ func (c *Client) StartDisconnectionTimmer(s *dgo.Session, tickEvery int) chan st
 					timers[guildId] = 0
 				}
 
-				if timers[guildId] >= MAX_IDLE_SECONDS {
-					if err := s.VoiceConnections[guildId].Disconnect(); err != nil {
-						log.Println("[VOICE_IDLE_ERR]:", VOICE_IDLE_ERR)
+				if c.Settings.Get(guildId).ShouldLeave(timers[guildId], alone) {
+					if err := c.leaveIdle(s, guildId, alone); err != nil {
 						// do not reset timer, try again later
 						continue
 					}
-
-					channelId := c.ActiveChannels[guildId]
-					_, err := s.ChannelMessageSend(channelId, "Leaving channel as I've been idle for more than 5 minutes...")
-					if err != nil {
-						log.Printf("Failed sending disconnection message to channel %s with guildId %s\n", channelId, guildId)
-					}
 					timers[guildId] = 0
 				}
 			}
@@ -195,6 +492,26 @@ func (c *Client) StartDisconnectionTimmer(s *dgo.Session, tickEvery int) chan st
 	return stop
 }
 
+// Leaves the voice channel of guildId, announcing why. alone tells whether it's because nobody is listening anymore.
+func (c *Client) leaveIdle(s *dgo.Session, guildId string, alone bool) error {
+	voiceConnection, ok := s.VoiceConnections[guildId]
+	if !ok {
+		return nil
+	}
+	if err := voiceConnection.Disconnect(); err != nil {
+		log.Println("[VOICE_IDLE_ERR]:", VOICE_IDLE_ERR)
+		return err
+	}
+
+	settings := c.Settings.Get(guildId)
+	msg := fmt.Sprintf("Leaving channel as I've been idle for more than %s...", describeIdleTimeout(settings.IdleTimeoutSeconds()))
+	if alone && settings.IdleMode == IDLE_MODE_ALONE {
+		msg = "Leaving channel as everyone left..."
+	}
+	c.Announce(s, guildId, EVENT_IDLE_LEAVE, msg)
+	return nil
+}
+
 func (c *Client) PlayCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 	if err := InteractionRespondDeferred(s, i); err != nil {
 		log.Printf(
@@ -204,9 +521,7 @@ func (c *Client) PlayCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 		)
 	}
 
-	voiceChannelId := ""
-	g, err := s.State.Guild(i.GuildID)
-	if err != nil {
+	if _, err := s.State.Guild(i.GuildID); err != nil {
 		err = InteractionTextUpdate(s, i, "Couldn't find any guild with id: "+i.GuildID) // Unlikely to happen
 		if err != nil {
 			log.Printf("Failed sending client error message to guild %s, client_error: %s, error: %s",
@@ -218,39 +533,37 @@ func (c *Client) PlayCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 		return
 	}
 
-	for _, vs := range g.VoiceStates {
-		if vs.UserID == i.Member.User.ID {
-			voiceChannelId = vs.ChannelID
-			break
+	voiceConnection, err := JoinUserVoiceChannel(s, i.GuildID, i.Member.User.ID)
+	if err != nil {
+		err := InteractionTextUpdate(s, i, QUEUE_EMPTY_ERR)
+		if err != nil {
+			log.Printf("Failed sending client error message to guild %s, client_error: %s, error: %s",
+				i.GuildID,
+				QUEUE_EMPTY_ERR,
+				err,
+			)
 		}
+		return
 	}
 
-	voiceConnection, err := s.ChannelVoiceJoin(i.GuildID, voiceChannelId, false, true)
-	if err != nil {
-		if _, ok := s.VoiceConnections[i.GuildID]; ok {
-			voiceConnection = s.VoiceConnections[i.GuildID]
+	options := InteractionOptions(i)
+
+	var track Track
+	if options.Has("file") {
+		attachment := options.Attachment(i, "file")
+		if attachment == nil {
+			err = fmt.Errorf("attachment %s not found", options.ID("file"))
 		} else {
-			err := InteractionTextUpdate(s, i, QUEUE_EMPTY_ERR)
-			if err != nil {
-				log.Printf("Failed sending client error message to guild %s, client_error: %s, error: %s",
-					i.GuildID,
-					QUEUE_EMPTY_ERR,
-					err,
-				)
-			}
-			return
+			track, err = ResolveAttachment(attachment)
 		}
+	} else if userInput := options.String("input", ""); userInput != "" {
+		track, err = DefaultResolver.Resolve(userInput)
+	} else {
+		err = fmt.Errorf("neither input nor file were given")
 	}
 
-	options := i.ApplicationCommandData().Options
-	optionsMap := make(map[string]*dgo.ApplicationCommandInteractionDataOption, len(options))
-	for _, opt := range options {
-		optionsMap[opt.Name] = opt
-	}
-
-	userInput := optionsMap["input"].Value.(string) // assume a string, because yes
-	track, err := ResolveAudioSource(userInput)
 	if err != nil {
+		log.Printf("Failed resolving track in guild %s, error: %s", i.GuildID, err)
 		err = InteractionTextUpdate(s, i, BAD_COMMAND_ARG_ERR)
 		if err != nil {
 			log.Printf("Failed sending client error message to guild %s, client_error: %s, error: %s",
@@ -262,8 +575,14 @@ func (c *Client) PlayCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 		return
 	}
 
+	c.playTrack(s, i, voiceConnection, track)
+}
+
+// Plays or enqueues an already resolved track, reporting what happened as
+// the interaction response.
+func (c *Client) playTrack(s *dgo.Session, i *dgo.InteractionCreate, voiceConnection *dgo.VoiceConnection, track Track) {
 	var playback *Playback
-	if p, ok := c.Players[i.GuildID]; ok {
+	if p, ok := c.Playback(i.GuildID); ok {
 		playback = p
 	} else {
 		err := InteractionTextUpdate(s, i, NO_PLAYER_AVAILABLE_ERR)
@@ -277,25 +596,17 @@ func (c *Client) PlayCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 		return
 	}
 
-	if playback.Player.State == enc.PlayerStatePlaying || playback.Player.State == enc.PlayerStatePaused {
-		msg := fmt.Sprintf("Track %s | %s added to queue", track.Title, track.WebURL)
-		err := InteractionTextUpdate(s, i, msg)
-		if err != nil {
-			log.Printf("Failed sending client message to guild %s, client_message: %s, error: %s",
-				i.GuildID,
-				msg,
-				err,
-			)
-		}
-		playback.Queue = append(playback.Queue, track)
-		return
+	playback.setVoiceConnection(voiceConnection)
+	track.Requester = i.Member.User.Username
+	track.RequesterID = i.Member.User.ID
+	msg := playback.NowPlayingMessage(track)
+	started, err := playback.PlayOrEnqueue(track)
+	if err != nil {
+		msg = err.Error()
+	} else if !started {
+		msg = fmt.Sprintf("Track %s | %s added to queue", track.Title, track.WebURL)
 	}
 
-	playback.MediaURL = track.MediaURL
-	playback.WebURL = track.WebURL
-	playback.Title = track.Title
-
-	msg := fmt.Sprintf("Now playing %s | %s", track.Title, track.WebURL)
 	if err := InteractionTextUpdate(s, i, msg); err != nil {
 		log.Printf("Failed sending client message to guild %s, client_message: %s, error: %s",
 			i.GuildID,
@@ -303,13 +614,6 @@ func (c *Client) PlayCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 			err,
 		)
 	}
-
-	playback.voiceConnection = voiceConnection
-	PlayMediaInVoiceChannel(track.MediaURL, playback.Player,
-		voiceConnection,
-		playback.ErrorChannel,
-		playback.CommandChannel,
-		playback.ResponseChannel)
 }
 
 func (c *Client) NextCommand(s *dgo.Session, i *dgo.InteractionCreate) {
@@ -322,7 +626,7 @@ func (c *Client) NextCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 	}
 
 	var playback *Playback
-	if p, ok := c.Players[i.GuildID]; ok {
+	if p, ok := c.Playback(i.GuildID); ok {
 		playback = p
 	} else {
 		err := InteractionTextUpdate(s, i, NO_PLAYER_AVAILABLE_ERR)
@@ -337,7 +641,7 @@ func (c *Client) NextCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 		return
 	}
 
-	if playback.voiceConnection == nil {
+	if playback.VoiceConnection() == nil {
 		err := InteractionTextUpdate(s, i, NO_VOICE_CONNECTION_ERR)
 		if err != nil {
 			log.Printf("Failed sending client error message to guild %s, client_error: %s, error: %s",
@@ -349,7 +653,7 @@ func (c *Client) NextCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 		return
 	}
 
-	if playback.Player.State == enc.PlayerStateIdle || len(playback.Queue) == 0 {
+	if playback.Player.State == enc.PlayerStateIdle || playback.Queue.Len() == 0 {
 		err := InteractionTextUpdate(s, i, QUEUE_EMPTY_ERR)
 		if err != nil {
 			log.Printf("Failed sending client error message to guild %s, client_error: %s, error: %s",
@@ -361,14 +665,19 @@ func (c *Client) NextCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 		return
 	}
 
-	track := playback.Queue[0]
-	playback.Queue = playback.Queue[1:]
+	if !c.CanSkip(s, playback, i.Member) {
+		c.voteSkipCommand(s, i, playback)
+		return
+	}
 
-	playback.MediaURL = track.MediaURL
-	playback.WebURL = track.WebURL
-	playback.Title = track.Title
+	c.Podcasts.Snapshot(playback)
+	track, ok := playback.Skip()
+	if !ok {
+		InteractionErrorUpdate(s, i, QUEUE_EMPTY_ERR)
+		return
+	}
 
-	msg := fmt.Sprintf("Now playing %s | %s", track.Title, track.WebURL)
+	msg := playback.NowPlayingMessage(track)
 	err := InteractionTextUpdate(s, i, msg)
 	if err != nil {
 		log.Printf("Failed sending client message to guild %s, client_message: %s, error: %s",
@@ -377,16 +686,6 @@ func (c *Client) NextCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 			err,
 		)
 	}
-
-	if playback.Player.State == enc.PlayerStatePlaying {
-		playback.CommandChannel <- enc.CommandStop{}
-	}
-
-	PlayMediaInVoiceChannel(track.MediaURL, playback.Player,
-		playback.voiceConnection,
-		playback.ErrorChannel,
-		playback.CommandChannel,
-		playback.ResponseChannel)
 }
 
 func (c *Client) StopCommand(s *dgo.Session, i *dgo.InteractionCreate) {
@@ -399,7 +698,7 @@ func (c *Client) StopCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 	}
 
 	var playback *Playback
-	if p, ok := c.Players[i.GuildID]; ok {
+	if p, ok := c.Playback(i.GuildID); ok {
 		playback = p
 	} else {
 		err := InteractionTextUpdate(s, i, NO_PLAYER_AVAILABLE_ERR)
@@ -413,7 +712,7 @@ func (c *Client) StopCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 		return
 	}
 
-	if playback.voiceConnection == nil {
+	if playback.VoiceConnection() == nil {
 		err := InteractionTextUpdate(s, i, NO_VOICE_CONNECTION_ERR)
 		if err != nil {
 			log.Printf("Failed sending client error message to guild %s, client_error: %s, error: %s",
@@ -437,7 +736,8 @@ func (c *Client) StopCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 		return
 	}
 
-	playback.CommandChannel <- enc.CommandStop{}
+	c.Podcasts.Snapshot(playback)
+	playback.Stop()
 	msg := fmt.Sprintf("Track %s | %s has been stopped", playback.Title, playback.WebURL)
 	err := InteractionTextUpdate(s, i, msg)
 	if err != nil {
@@ -459,7 +759,7 @@ func (c *Client) PauseCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 	}
 
 	var playback *Playback
-	if p, ok := c.Players[i.GuildID]; ok {
+	if p, ok := c.Playback(i.GuildID); ok {
 		playback = p
 	} else {
 		err := InteractionTextUpdate(s, i, NO_PLAYER_AVAILABLE_ERR)
@@ -473,7 +773,7 @@ func (c *Client) PauseCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 		return
 	}
 
-	if playback.voiceConnection == nil {
+	if playback.VoiceConnection() == nil {
 		err := InteractionTextUpdate(s, i, NO_VOICE_CONNECTION_ERR)
 		if err != nil {
 			log.Printf("Failed sending client error message to guild %s, client_error: %s, error: %s",
@@ -498,6 +798,7 @@ func (c *Client) PauseCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 	}
 
 	playback.CommandChannel <- enc.CommandPause{}
+	c.Podcasts.Snapshot(playback)
 	msg := fmt.Sprintf("Track %s | %s has been paused", playback.Title, playback.WebURL)
 	err := InteractionTextUpdate(s, i, msg)
 	if err != nil {
@@ -519,7 +820,7 @@ func (c *Client) ResumeCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 	}
 
 	var playback *Playback
-	if p, ok := c.Players[i.GuildID]; ok {
+	if p, ok := c.Playback(i.GuildID); ok {
 		playback = p
 	} else {
 		err := InteractionTextUpdate(s, i, NO_PLAYER_AVAILABLE_ERR)
@@ -533,7 +834,7 @@ func (c *Client) ResumeCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 		return
 	}
 
-	if playback.voiceConnection == nil {
+	if playback.VoiceConnection() == nil {
 		err := InteractionTextUpdate(s, i, NO_VOICE_CONNECTION_ERR)
 		if err != nil {
 			log.Printf("Failed sending client error message to guild %s, client_error: %s, error: %s",
@@ -578,15 +879,10 @@ func (c *Client) SeekCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 		)
 	}
 
-	options := i.ApplicationCommandData().Options
-	optionsMap := make(map[string]*dgo.ApplicationCommandInteractionDataOption, len(options))
-	for _, opt := range options {
-		optionsMap[opt.Name] = opt
-	}
-	userInput := int(optionsMap["input"].Value.(float64))
+	userInput := InteractionOptions(i).Int("input", 0)
 
 	var playback *Playback
-	if p, ok := c.Players[i.GuildID]; ok {
+	if p, ok := c.Playback(i.GuildID); ok {
 		playback = p
 	} else {
 		err := InteractionTextUpdate(s, i, NO_PLAYER_AVAILABLE_ERR)
@@ -600,7 +896,7 @@ func (c *Client) SeekCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 		return
 	}
 
-	if playback.voiceConnection == nil {
+	if playback.VoiceConnection() == nil {
 		err := InteractionTextUpdate(s, i, NO_VOICE_CONNECTION_ERR)
 		if err != nil {
 			log.Printf("Failed sending client error message to guild %s, client_error: %s, error: %s",
@@ -624,15 +920,37 @@ func (c *Client) SeekCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 		return
 	}
 
-	playback.CommandChannel <- enc.CommandGetDuration{}
-	durationResponse := <-playback.ResponseChannel
-	duration := int(durationResponse.(enc.ResponseDuration))
+	durationResponse, _ := playback.query(enc.CommandGetDuration{})
+	duration, ok := durationResponse.(enc.ResponseDuration)
+	if !ok {
+		err := InteractionTextUpdate(s, i, NO_PLAYER_AVAILABLE_ERR)
+		if err != nil {
+			log.Printf("Failed sending client error message to guild %s, client_error: %s, error: %s",
+				i.GuildID,
+				NO_PLAYER_AVAILABLE_ERR,
+				err,
+			)
+		}
+		return
+	}
 
-	playback.CommandChannel <- enc.CommandGetPlaybackTime{}
-	currentTime := int((<-playback.ResponseChannel).(enc.ResponsePlaybackTime))
+	timeResponse, _ := playback.query(enc.CommandGetPlaybackTime{})
+	playbackTime, ok := timeResponse.(enc.ResponsePlaybackTime)
+	if !ok {
+		err := InteractionTextUpdate(s, i, NO_TRACK_PLAYING_ERR)
+		if err != nil {
+			log.Printf("Failed sending client error message to guild %s, client_error: %s, error: %s",
+				i.GuildID,
+				NO_TRACK_PLAYING_ERR,
+				err,
+			)
+		}
+		return
+	}
+	currentTime := int(playbackTime)
 
 	cursor := currentTime + userInput
-	if cursor > duration {
+	if cursor > int(duration) {
 		err := InteractionTextUpdate(s, i, SEEK_TOO_FAR_ERR)
 		if err != nil {
 			log.Printf("Failed sending client error message to guild %s, client_error: %s, error: %s",
@@ -661,6 +979,10 @@ func (c *Client) SeekCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 
 func (c *Client) AliveCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 	msg := "I'm alive :)"
+	if version := YTDLPVersion(); version != "" {
+		msg += fmt.Sprintf(" (yt-dlp %s)", version)
+	}
+	msg += "\n" + DefaultResolver.Stats().String()
 	err := InteractionTextRespond(s, i, msg)
 	if err != nil {
 		log.Printf("Failed sending client message to guild %s, client_message: %s, error: %s",
@@ -672,16 +994,18 @@ func (c *Client) AliveCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 }
 
 func (c *Client) LeaveCommand(s *dgo.Session, i *dgo.InteractionCreate) {
-	connection := c.Players[i.GuildID].voiceConnection
-	if connection == nil {
+	playback, ok := c.Playback(i.GuildID)
+	if !ok || playback.VoiceConnection() == nil {
 		ReportGenericError("No connection to end", s, i)
 		return
 	}
+	connection := playback.VoiceConnection()
 
 	// Stop the player/encoder if it's running for any reason
-	if c.Players[i.GuildID].Player.State == enc.PlayerStatePaused ||
-		c.Players[i.GuildID].Player.State == enc.PlayerStatePlaying {
-		c.Players[i.GuildID].CommandChannel <- enc.CommandStop{}
+	if playback.Player.State == enc.PlayerStatePaused ||
+		playback.Player.State == enc.PlayerStatePlaying {
+		c.Podcasts.Snapshot(playback)
+		playback.Stop()
 	}
 
 	err := connection.Disconnect()
@@ -692,6 +1016,23 @@ func (c *Client) LeaveCommand(s *dgo.Session, i *dgo.InteractionCreate) {
 	}
 }
 
+// Defers the response and looks up the guild playback, reporting its absence
+func (c *Client) deferredPlayback(s *dgo.Session, i *dgo.InteractionCreate) (*Playback, bool) {
+	if err := InteractionRespondDeferred(s, i); err != nil {
+		log.Printf(
+			"Failed sending deferred response into guild: %s, error: %s",
+			i.GuildID,
+			err,
+		)
+	}
+
+	playback, ok := c.Playback(i.GuildID)
+	if !ok {
+		InteractionErrorUpdate(s, i, NO_PLAYER_AVAILABLE_ERR)
+	}
+	return playback, ok
+}
+
 func ReportGenericError(msg string, session *dgo.Session, interaction *dgo.InteractionCreate) {
 	err := InteractionTextRespond(session, interaction, msg)
 	if err != nil {
@@ -0,0 +1,673 @@
+package main
+
+import (
+	"time"
+
+	dgo "github.com/bwmarrin/discordgo"
+)
+
+const (
+	ALIVE_COMMAND_NAME       = "alive"
+	PLAY_COMMAND_NAME        = "play"
+	NEXT_COMMAND_NAME        = "next"
+	SKIP_COMMAND_NAME        = "skip" // Alias for /next
+	STOP_COMMAND_NAME        = "stop"
+	PAUSE_COMMAND_NAME       = "pause"
+	RESUME_COMMAND_NAME      = "resume"
+	SEEK_COMMAND_NAME        = "ff"
+	LEAVE_COMMAND_NAME       = "leave"
+	PODCAST_COMMAND_NAME     = "podcast"
+	SAY_COMMAND_NAME         = "say"
+	QUEUE_COMMAND_NAME       = "queue"
+	REMOVE_COMMAND_NAME      = "remove"
+	MOVE_COMMAND_NAME        = "move"
+	CLEAR_COMMAND_NAME       = "clear"
+	JUMP_COMMAND_NAME        = "jump"
+	SHUFFLE_COMMAND_NAME     = "shuffle"
+	DEDUPE_COMMAND_NAME      = "dedupe"
+	LOOP_COMMAND_NAME        = "loop"
+	PREVIOUS_COMMAND_NAME    = "previous"
+	HISTORY_COMMAND_NAME     = "history"
+	PLAYLIST_COMMAND_NAME    = "playlist"
+	CONFIG_COMMAND_NAME      = "config"
+	AUTOPLAY_COMMAND_NAME    = "autoplay"
+	NOW_PLAYING_COMMAND_NAME = "nowplaying"
+)
+
+var manageServerPermission int64 = dgo.PermissionManageServer
+
+var minQueuePosition float64 = 1
+
+var minIdleMinutes float64 = 1
+
+var playlistScopeOption = &dgo.ApplicationCommandOption{
+	Name:        "scope",
+	Type:        dgo.ApplicationCommandOptionString,
+	Description: "Whether the playlist is yours or the server's (defaults to yours)",
+	Choices: []*dgo.ApplicationCommandOptionChoice{
+		{Name: "user", Value: PLAYLIST_SCOPE_USER},
+		{Name: "guild", Value: PLAYLIST_SCOPE_GUILD},
+	},
+}
+
+var playlistNameOption = &dgo.ApplicationCommandOption{
+	Name:        "name",
+	Type:        dgo.ApplicationCommandOptionString,
+	Description: "Name of the playlist",
+	Required:    true,
+}
+
+func settingOption(required bool) *dgo.ApplicationCommandOption {
+	choices := make([]*dgo.ApplicationCommandOptionChoice, 0, len(settingsList))
+	for _, s := range settingsList {
+		choices = append(choices, &dgo.ApplicationCommandOptionChoice{Name: s.Name, Value: s.Name})
+	}
+
+	return &dgo.ApplicationCommandOption{
+		Name:        "setting",
+		Type:        dgo.ApplicationCommandOptionString,
+		Description: "Setting to configure",
+		Required:    required,
+		Choices:     choices,
+	}
+}
+
+func announceEventOption() *dgo.ApplicationCommandOption {
+	choices := make([]*dgo.ApplicationCommandOptionChoice, 0, len(AnnounceEvents))
+	for _, event := range AnnounceEvents {
+		choices = append(choices, &dgo.ApplicationCommandOptionChoice{Name: event.Name, Value: event.Name})
+	}
+
+	return &dgo.ApplicationCommandOption{
+		Name:        "event",
+		Type:        dgo.ApplicationCommandOptionString,
+		Description: "Event to announce or not",
+		Required:    true,
+		Choices:     choices,
+	}
+}
+
+func restrictableCommandOption(names []string) *dgo.ApplicationCommandOption {
+	choices := make([]*dgo.ApplicationCommandOptionChoice, 0, len(names))
+	for _, name := range names {
+		choices = append(choices, &dgo.ApplicationCommandOptionChoice{Name: name, Value: name})
+	}
+
+	return &dgo.ApplicationCommandOption{
+		Name:        "command",
+		Type:        dgo.ApplicationCommandOptionString,
+		Description: "Command to configure",
+		Required:    true,
+		Choices:     choices,
+	}
+}
+
+// Every command of the bot, with the handlers of client
+func (c *Client) CommandRegistry() *CommandRegistry {
+	r := NewCommandRegistry()
+	r.Add([]CommandSpec{
+		{
+			Command: &dgo.ApplicationCommand{
+				Name:        PLAY_COMMAND_NAME,
+				Description: "Plays a song",
+				Options: []*dgo.ApplicationCommandOption{
+					{
+						Name:         "input",
+						Type:         dgo.ApplicationCommandOptionString,
+						Description:  "Raw media URL | YT web url | YT searchbar",
+						Autocomplete: true,
+					},
+					{
+						Name:        "file",
+						Type:        dgo.ApplicationCommandOptionAttachment,
+						Description: "Audio or video file (mp3, ogg, flac, mp4)",
+					},
+				},
+			},
+			Handler:      c.PlayCommand,
+			Autocomplete: c.PlayAutocomplete,
+			Cooldown:     2 * time.Second,
+		},
+		{
+			Command: &dgo.ApplicationCommand{
+				Name:        SEEK_COMMAND_NAME,
+				Description: "Fast forwards a song by a certain amount of seconds",
+				Options: []*dgo.ApplicationCommandOption{
+					{
+						Name:        "input",
+						Type:        dgo.ApplicationCommandOptionInteger,
+						Description: "Amount of seconds to skip",
+						Required:    true,
+					},
+				},
+			},
+			Handler:      c.SeekCommand,
+			Restrictable: true,
+		},
+		{
+			Command: &dgo.ApplicationCommand{
+				Name:        STOP_COMMAND_NAME,
+				Description: "Stops the current song",
+			},
+			Handler:      c.StopCommand,
+			Restrictable: true,
+		},
+		{
+			Command: &dgo.ApplicationCommand{
+				Name:        NEXT_COMMAND_NAME,
+				Description: "Play the next song immediately",
+			},
+			Handler:      c.NextCommand,
+			Restrictable: true,
+		},
+		{
+			Command: &dgo.ApplicationCommand{
+				Name:        SKIP_COMMAND_NAME,
+				Description: "Play the next song immediately",
+			},
+			Handler: c.NextCommand,
+		},
+		{
+			Command: &dgo.ApplicationCommand{
+				Name:        RESUME_COMMAND_NAME,
+				Description: "Resumes a paused song",
+			},
+			Handler:      c.ResumeCommand,
+			Restrictable: true,
+		},
+		{
+			Command: &dgo.ApplicationCommand{
+				Name:        PAUSE_COMMAND_NAME,
+				Description: "Pauses a playing song",
+			},
+			Handler:      c.PauseCommand,
+			Restrictable: true,
+		},
+		{
+			Command: &dgo.ApplicationCommand{
+				Name:        ALIVE_COMMAND_NAME,
+				Description: "Am I alive? o.O",
+			},
+			Handler: c.AliveCommand,
+		},
+		{
+			Command: &dgo.ApplicationCommand{
+				Name:        LEAVE_COMMAND_NAME,
+				Description: "I'll leave the channel :(",
+			},
+			Handler:      c.LeaveCommand,
+			Restrictable: true,
+		},
+		{
+			Command: &dgo.ApplicationCommand{
+				Name:        PODCAST_COMMAND_NAME,
+				Description: "Plays podcast episodes",
+				Options: []*dgo.ApplicationCommandOption{
+					{
+						Name:        "feed",
+						Type:        dgo.ApplicationCommandOptionSubCommand,
+						Description: "Lists the recent episodes of a podcast",
+						Options: []*dgo.ApplicationCommandOption{
+							{
+								Name:        "url",
+								Type:        dgo.ApplicationCommandOptionString,
+								Description: "RSS or Atom feed URL",
+								Required:    true,
+							},
+						},
+					},
+					{
+						Name:        "resume",
+						Type:        dgo.ApplicationCommandOptionSubCommand,
+						Description: "Continues the last episode you listened to",
+					},
+				},
+			},
+			Handler:  c.PodcastCommand,
+			Cooldown: 5 * time.Second,
+		},
+		{
+			Command: &dgo.ApplicationCommand{
+				Name:        QUEUE_COMMAND_NAME,
+				Description: "Shows the tracks waiting to be played",
+			},
+			Handler: c.QueueCommand,
+		},
+		{
+			Command: &dgo.ApplicationCommand{
+				Name:        REMOVE_COMMAND_NAME,
+				Description: "Removes a track from the queue",
+				Options: []*dgo.ApplicationCommandOption{
+					{
+						Name:        "position",
+						Type:        dgo.ApplicationCommandOptionInteger,
+						Description: "Position of the track in the queue",
+						Required:    true,
+						MinValue:    &minQueuePosition,
+					},
+				},
+			},
+			Handler:      c.RemoveCommand,
+			Restrictable: true,
+		},
+		{
+			Command: &dgo.ApplicationCommand{
+				Name:        MOVE_COMMAND_NAME,
+				Description: "Moves a track to another position in the queue",
+				Options: []*dgo.ApplicationCommandOption{
+					{
+						Name:        "from",
+						Type:        dgo.ApplicationCommandOptionInteger,
+						Description: "Current position of the track",
+						Required:    true,
+						MinValue:    &minQueuePosition,
+					},
+					{
+						Name:        "to",
+						Type:        dgo.ApplicationCommandOptionInteger,
+						Description: "Position the track will be moved to",
+						Required:    true,
+						MinValue:    &minQueuePosition,
+					},
+				},
+			},
+			Handler:      c.MoveCommand,
+			Restrictable: true,
+		},
+		{
+			Command: &dgo.ApplicationCommand{
+				Name:        CLEAR_COMMAND_NAME,
+				Description: "Removes every track from the queue",
+			},
+			Handler:      c.ClearCommand,
+			Restrictable: true,
+		},
+		{
+			Command: &dgo.ApplicationCommand{
+				Name:        JUMP_COMMAND_NAME,
+				Description: "Plays a track in the queue right away, dropping the ones before it",
+				Options: []*dgo.ApplicationCommandOption{
+					{
+						Name:        "position",
+						Type:        dgo.ApplicationCommandOptionInteger,
+						Description: "Position of the track in the queue",
+						Required:    true,
+						MinValue:    &minQueuePosition,
+					},
+				},
+			},
+			Handler:      c.JumpCommand,
+			Restrictable: true,
+		},
+		{
+			Command: &dgo.ApplicationCommand{
+				Name:        SHUFFLE_COMMAND_NAME,
+				Description: "Shuffles the queue",
+			},
+			Handler:      c.ShuffleCommand,
+			Restrictable: true,
+		},
+		{
+			Command: &dgo.ApplicationCommand{
+				Name:        DEDUPE_COMMAND_NAME,
+				Description: "Removes duplicate tracks from the queue",
+			},
+			Handler:      c.DedupeCommand,
+			Restrictable: true,
+		},
+		{
+			Command: &dgo.ApplicationCommand{
+				Name:        LOOP_COMMAND_NAME,
+				Description: "Loops the current track or the whole queue",
+				Options: []*dgo.ApplicationCommandOption{
+					{
+						Name:        "mode",
+						Type:        dgo.ApplicationCommandOptionString,
+						Description: "What to loop",
+						Required:    true,
+						Choices: []*dgo.ApplicationCommandOptionChoice{
+							{Name: "track", Value: LoopTrack.String()},
+							{Name: "queue", Value: LoopQueue.String()},
+							{Name: "off", Value: LoopOff.String()},
+						},
+					},
+				},
+			},
+			Handler:      c.LoopCommand,
+			Restrictable: true,
+		},
+		{
+			Command: &dgo.ApplicationCommand{
+				Name:        PREVIOUS_COMMAND_NAME,
+				Description: "Plays the previous track again",
+			},
+			Handler:      c.PreviousCommand,
+			Restrictable: true,
+		},
+		{
+			Command: &dgo.ApplicationCommand{
+				Name:        HISTORY_COMMAND_NAME,
+				Description: "Lists the recently played tracks",
+			},
+			Handler: c.HistoryCommand,
+		},
+		{
+			Command: &dgo.ApplicationCommand{
+				Name:        PLAYLIST_COMMAND_NAME,
+				Description: "Manages saved playlists",
+				Options: []*dgo.ApplicationCommandOption{
+					{
+						Name:        "create",
+						Type:        dgo.ApplicationCommandOptionSubCommand,
+						Description: "Creates an empty playlist",
+						Options:     []*dgo.ApplicationCommandOption{playlistNameOption, playlistScopeOption},
+					},
+					{
+						Name:        "add",
+						Type:        dgo.ApplicationCommandOptionSubCommand,
+						Description: "Adds the current track or the whole queue to a playlist",
+						Options: []*dgo.ApplicationCommandOption{
+							playlistNameOption,
+							{
+								Name:        "source",
+								Type:        dgo.ApplicationCommandOptionString,
+								Description: "What to add (defaults to the current track)",
+								Choices: []*dgo.ApplicationCommandOptionChoice{
+									{Name: "current track", Value: "current"},
+									{Name: "whole queue", Value: "queue"},
+								},
+							},
+							playlistScopeOption,
+						},
+					},
+					{
+						Name:        "remove",
+						Type:        dgo.ApplicationCommandOptionSubCommand,
+						Description: "Removes a track from a playlist",
+						Options: []*dgo.ApplicationCommandOption{
+							playlistNameOption,
+							{
+								Name:        "position",
+								Type:        dgo.ApplicationCommandOptionInteger,
+								Description: "Position of the track in the playlist",
+								Required:    true,
+								MinValue:    &minQueuePosition,
+							},
+							playlistScopeOption,
+						},
+					},
+					{
+						Name:        "show",
+						Type:        dgo.ApplicationCommandOptionSubCommand,
+						Description: "Lists the tracks of a playlist",
+						Options:     []*dgo.ApplicationCommandOption{playlistNameOption, playlistScopeOption},
+					},
+					{
+						Name:        "play",
+						Type:        dgo.ApplicationCommandOptionSubCommand,
+						Description: "Adds every track of a playlist to the queue",
+						Options:     []*dgo.ApplicationCommandOption{playlistNameOption, playlistScopeOption},
+					},
+					{
+						Name:        "delete",
+						Type:        dgo.ApplicationCommandOptionSubCommand,
+						Description: "Deletes a playlist",
+						Options:     []*dgo.ApplicationCommandOption{playlistNameOption, playlistScopeOption},
+					},
+					{
+						Name:        "import",
+						Type:        dgo.ApplicationCommandOptionSubCommand,
+						Description: "Adds the tracks of a JSON or M3U file to a playlist",
+						Options: []*dgo.ApplicationCommandOption{
+							playlistNameOption,
+							{
+								Name:        "file",
+								Type:        dgo.ApplicationCommandOptionAttachment,
+								Description: "JSON export or M3U file",
+								Required:    true,
+							},
+							playlistScopeOption,
+						},
+					},
+					{
+						Name:        "export",
+						Type:        dgo.ApplicationCommandOptionSubCommand,
+						Description: "Sends a playlist as a file",
+						Options: []*dgo.ApplicationCommandOption{
+							playlistNameOption,
+							{
+								Name:        "format",
+								Type:        dgo.ApplicationCommandOptionString,
+								Description: "File format (defaults to json)",
+								Choices: []*dgo.ApplicationCommandOptionChoice{
+									{Name: "json", Value: "json"},
+									{Name: "m3u", Value: "m3u"},
+								},
+							},
+							playlistScopeOption,
+						},
+					},
+				},
+			},
+			Handler:  c.PlaylistCommand,
+			Cooldown: 3 * time.Second,
+		},
+		{
+			Command: &dgo.ApplicationCommand{
+				Name:        AUTOPLAY_COMMAND_NAME,
+				Description: "Keeps playing related tracks once the queue is over",
+				Options: []*dgo.ApplicationCommandOption{
+					{
+						Name:        "enabled",
+						Type:        dgo.ApplicationCommandOptionBoolean,
+						Description: "Whether autoplay is on (toggles it when omitted)",
+					},
+				},
+			},
+			Handler:      c.AutoplayCommand,
+			Restrictable: true,
+		},
+		{
+			Command: &dgo.ApplicationCommand{
+				Name:        NOW_PLAYING_COMMAND_NAME,
+				Description: "Shows the current track with playback controls",
+			},
+			Handler: c.NowPlayingCommand,
+		},
+		{
+			Command: &dgo.ApplicationCommand{
+				Name:        SAY_COMMAND_NAME,
+				Description: "Says something in the voice channel",
+				Options: []*dgo.ApplicationCommandOption{
+					{
+						Name:        "text",
+						Type:        dgo.ApplicationCommandOptionString,
+						Description: "What to say",
+						Required:    true,
+					},
+				},
+			},
+			Handler:      c.SayCommand,
+			Restrictable: true,
+			Cooldown:     5 * time.Second,
+		},
+	}...)
+
+	// Lists the commands above which can be restricted
+	restrictable := r.Restrictable()
+	r.Add(CommandSpec{
+		Command: &dgo.ApplicationCommand{
+			Name:                     CONFIG_COMMAND_NAME,
+			Description:              "Configures the bot for this server",
+			DefaultMemberPermissions: &manageServerPermission,
+			Options: []*dgo.ApplicationCommandOption{
+				{
+					Name:        "get",
+					Type:        dgo.ApplicationCommandOptionSubCommand,
+					Description: "Shows a setting, or all of them when none is given",
+					Options:     []*dgo.ApplicationCommandOption{settingOption(false)},
+				},
+				{
+					Name:        "set",
+					Type:        dgo.ApplicationCommandOptionSubCommand,
+					Description: "Changes a setting",
+					Options: []*dgo.ApplicationCommandOption{
+						settingOption(true),
+						{
+							Name:        "value",
+							Type:        dgo.ApplicationCommandOptionString,
+							Description: "New value, channels and roles can be mentioned",
+							Required:    true,
+						},
+					},
+				},
+				{
+					Name:        "reset",
+					Type:        dgo.ApplicationCommandOptionSubCommand,
+					Description: "Puts a setting back to its default, or all of them when none is given",
+					Options:     []*dgo.ApplicationCommandOption{settingOption(false)},
+				},
+				{
+					Name:        "permissions",
+					Type:        dgo.ApplicationCommandOptionSubCommandGroup,
+					Description: "Who can use which command",
+					Options: []*dgo.ApplicationCommandOption{
+						{
+							Name:        "show",
+							Type:        dgo.ApplicationCommandOptionSubCommand,
+							Description: "Shows the DJ role and the restricted commands",
+						},
+						{
+							Name:        "dj-role",
+							Type:        dgo.ApplicationCommandOptionSubCommand,
+							Description: "Sets the DJ role, or clears it when no role is given",
+							Options: []*dgo.ApplicationCommandOption{
+								{
+									Name:        "role",
+									Type:        dgo.ApplicationCommandOptionRole,
+									Description: "The DJ role",
+								},
+							},
+						},
+						{
+							Name:        "allow",
+							Type:        dgo.ApplicationCommandOptionSubCommand,
+							Description: "Restricts a command to a role or user, or to DJs when neither is given",
+							Options: []*dgo.ApplicationCommandOption{
+								restrictableCommandOption(restrictable),
+								{
+									Name:        "role",
+									Type:        dgo.ApplicationCommandOptionRole,
+									Description: "Role allowed to use the command",
+								},
+								{
+									Name:        "user",
+									Type:        dgo.ApplicationCommandOptionUser,
+									Description: "User allowed to use the command",
+								},
+							},
+						},
+						{
+							Name:        "reset",
+							Type:        dgo.ApplicationCommandOptionSubCommand,
+							Description: "Opens a command to everyone again",
+							Options:     []*dgo.ApplicationCommandOption{restrictableCommandOption(restrictable)},
+						},
+					},
+				},
+				{
+					Name:        "announce",
+					Type:        dgo.ApplicationCommandOptionSubCommandGroup,
+					Description: "Where and what the bot announces",
+					Options: []*dgo.ApplicationCommandOption{
+						{
+							Name:        "show",
+							Type:        dgo.ApplicationCommandOptionSubCommand,
+							Description: "Shows the announce channel and which events are announced",
+						},
+						{
+							Name:        "channel",
+							Type:        dgo.ApplicationCommandOptionSubCommand,
+							Description: "Sets the announce channel, or goes back to the last used channel when none is given",
+							Options: []*dgo.ApplicationCommandOption{
+								{
+									Name:         "channel",
+									Type:         dgo.ApplicationCommandOptionChannel,
+									Description:  "The text channel to announce in",
+									ChannelTypes: []dgo.ChannelType{dgo.ChannelTypeGuildText, dgo.ChannelTypeGuildNews},
+								},
+							},
+						},
+						{
+							Name:        "event",
+							Type:        dgo.ApplicationCommandOptionSubCommand,
+							Description: "Turns the announcements of an event on or off",
+							Options: []*dgo.ApplicationCommandOption{
+								announceEventOption(),
+								{
+									Name:        "enabled",
+									Type:        dgo.ApplicationCommandOptionBoolean,
+									Description: "Whether to announce the event",
+									Required:    true,
+								},
+							},
+						},
+					},
+				},
+				{
+					Name:        "idle",
+					Type:        dgo.ApplicationCommandOptionSubCommandGroup,
+					Description: "When the bot leaves the voice channel on its own",
+					Options: []*dgo.ApplicationCommandOption{
+						{
+							Name:        "show",
+							Type:        dgo.ApplicationCommandOptionSubCommand,
+							Description: "Shows when the bot leaves",
+						},
+						{
+							Name:        "timeout",
+							Type:        dgo.ApplicationCommandOptionSubCommand,
+							Description: "Leaves after idling or being alone for a while",
+							Options: []*dgo.ApplicationCommandOption{
+								{
+									Name:        "minutes",
+									Type:        dgo.ApplicationCommandOptionInteger,
+									Description: "How long to wait before leaving",
+									Required:    true,
+									MinValue:    &minIdleMinutes,
+									MaxValue:    MAX_IDLE_TIMEOUT / 60,
+								},
+							},
+						},
+						{
+							Name:        "alone",
+							Type:        dgo.ApplicationCommandOptionSubCommand,
+							Description: "Leaves as soon as everyone is gone",
+						},
+						{
+							Name:        "disable",
+							Type:        dgo.ApplicationCommandOptionSubCommand,
+							Description: "Never leaves on its own",
+						},
+						{
+							Name:        "stay",
+							Type:        dgo.ApplicationCommandOptionSubCommand,
+							Description: "Stays in a voice channel around the clock, or stops staying when no channel is given",
+							Options: []*dgo.ApplicationCommandOption{
+								{
+									Name:         "channel",
+									Type:         dgo.ApplicationCommandOptionChannel,
+									Description:  "The voice channel to stay in",
+									ChannelTypes: []dgo.ChannelType{dgo.ChannelTypeGuildVoice, dgo.ChannelTypeGuildStageVoice},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		Handler: c.ConfigCommand,
+	})
+
+	return r
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+
+	dgo "github.com/bwmarrin/discordgo"
+)
+
+// What makes two command registrations equivalent, leaving out ids, versions
+// and the defaults discord fills in.
+type commandShape struct {
+	Name        string
+	Description string
+	Type        dgo.ApplicationCommandType
+	Permissions string
+	Options     []optionShape
+}
+
+type optionShape struct {
+	Name         string
+	Description  string
+	Type         dgo.ApplicationCommandOptionType
+	Required     bool
+	Autocomplete bool
+	Choices      []string
+	MinValue     string // Empty when unset
+	MaxValue     string // Empty when unset
+	ChannelTypes []dgo.ChannelType
+	Options      []optionShape
+}
+
+func newOptionShapes(options []*dgo.ApplicationCommandOption) []optionShape {
+	if len(options) == 0 {
+		return nil
+	}
+
+	shapes := make([]optionShape, 0, len(options))
+	for _, opt := range options {
+		shape := optionShape{
+			Name:         opt.Name,
+			Description:  opt.Description,
+			Type:         opt.Type,
+			Required:     opt.Required,
+			Autocomplete: opt.Autocomplete,
+			ChannelTypes: opt.ChannelTypes,
+			Options:      newOptionShapes(opt.Options),
+		}
+		if len(shape.ChannelTypes) == 0 {
+			shape.ChannelTypes = nil
+		}
+		if opt.MinValue != nil {
+			shape.MinValue = fmt.Sprint(*opt.MinValue)
+		}
+		// Discord leaves out a max value of 0, same as no max value
+		if opt.MaxValue != 0 {
+			shape.MaxValue = fmt.Sprint(opt.MaxValue)
+		}
+		for _, choice := range opt.Choices {
+			shape.Choices = append(shape.Choices, fmt.Sprintf("%s=%v", choice.Name, choice.Value))
+		}
+		shapes = append(shapes, shape)
+	}
+	return shapes
+}
+
+func newCommandShapes(commands []*dgo.ApplicationCommand) []commandShape {
+	shapes := make([]commandShape, 0, len(commands))
+	for _, cmd := range commands {
+		shape := commandShape{
+			Name:        cmd.Name,
+			Description: cmd.Description,
+			Type:        cmd.Type,
+			Options:     newOptionShapes(cmd.Options),
+		}
+		if shape.Type == 0 {
+			shape.Type = dgo.ChatApplicationCommand
+		}
+		if cmd.DefaultMemberPermissions != nil {
+			shape.Permissions = fmt.Sprint(*cmd.DefaultMemberPermissions)
+		}
+		shapes = append(shapes, shape)
+	}
+
+	sort.Slice(shapes, func(a, b int) bool {
+		return shapes[a].Name < shapes[b].Name
+	})
+	return shapes
+}
+
+// Makes commands the only ones registered globally, or in a guild when
+// guildId isn't empty. Nothing gets sent when they're registered already.
+func SyncCommands(s *dgo.Session, guildId string, commands []*dgo.ApplicationCommand) error {
+	scope := "globally"
+	if guildId != "" {
+		scope = "in guild " + guildId
+	}
+
+	existing, err := s.ApplicationCommands(s.State.User.ID, guildId)
+	if err != nil {
+		return fmt.Errorf("failed listing commands %s: %w", scope, err)
+	}
+
+	if reflect.DeepEqual(newCommandShapes(existing), newCommandShapes(commands)) {
+		log.Printf("Commands %s are up to date (%d commands)\n", scope, len(commands))
+		return nil
+	}
+
+	if _, err := s.ApplicationCommandBulkOverwrite(s.State.User.ID, guildId, commands); err != nil {
+		return fmt.Errorf("failed overwriting commands %s: %w", scope, err)
+	}
+	log.Printf("Overwrote commands %s, %d registered before, %d now\n", scope, len(existing), len(commands))
+	return nil
+}
+
+// Registers commands either globally or in every guild, removing them from
+// the other scope so that they don't show up twice.
+func RegisterCommands(s *dgo.Session, guildIds []string, commands []*dgo.ApplicationCommand, global bool) error {
+	globalCommands, guildCommands := commands, []*dgo.ApplicationCommand{}
+	if !global {
+		globalCommands, guildCommands = guildCommands, globalCommands
+	}
+
+	if err := SyncCommands(s, "", globalCommands); err != nil {
+		return err
+	}
+	for _, guildId := range guildIds {
+		if err := SyncCommands(s, guildId, guildCommands); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Removes every command registration, globally and from every guild
+func RemoveAllCommands(s *dgo.Session, guildIds []string) {
+	empty := []*dgo.ApplicationCommand{}
+	if err := SyncCommands(s, "", empty); err != nil {
+		log.Println("[COMMANDS_ERR]:", err)
+	}
+	for _, guildId := range guildIds {
+		if err := SyncCommands(s, guildId, empty); err != nil {
+			log.Println("[COMMANDS_ERR]:", err)
+		}
+	}
+}
@@ -128,6 +128,7 @@ func (e *Enc) GetOpusFrames(input string, opts EncOptions, ch chan<- []byte, err
 	maxBytes := maxSamples * 2
 
 	nof := 0
+	trimmed := 0 // Frames already played and dropped from the cache
 
 	sampleBytes := make([]byte, maxBytes)
 	opusFrames := make([][]byte, 0, 512)
@@ -225,6 +226,7 @@ loop:
 			if !encoderPaused && cacheSize >= opts.MaxCacheBytes {
 				encoderPause <- struct{}{}
 				opusFrames = opusFrames[nof:]
+				trimmed += nof
 				nof = 0
 			}
 
@@ -249,11 +251,14 @@ loop:
 				playerPaused = false
 				e.Notify(PlayerEventResumed)
 			case CommandSeek:
-				nof = int(float32(v) * framesPerSecond)
+				nof = int(float32(v)*framesPerSecond) - trimmed
+				if nof < 0 {
+					nof = 0
+				}
 			case CommandGetPlaybackTime:
-				respCh <- ResponsePlaybackTime(float32(nof / int(framesPerSecond)))
+				respCh <- ResponsePlaybackTime(float32((trimmed + nof) / int(framesPerSecond)))
 			case CommandGetDuration:
-				respCh <- ResponseDuration(float32(len(opusFrames)) / framesPerSecond)
+				respCh <- ResponseDuration(float32(trimmed+len(opusFrames)) / framesPerSecond)
 			}
 		default:
 			time.Sleep(2 * time.Millisecond)
@@ -283,7 +288,6 @@ loop:
 		}
 	}
 
-	close(respCh)
 	e.State = PlayerStateIdle
 	e.Notify(PlayerEventTrackEnded)
 }
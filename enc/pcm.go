@@ -13,6 +13,7 @@ type PcmOptions struct {
 	SampleRate int
 	Seek       float32
 	Duration   float32
+	Volume     float32 // Gain applied to the audio, 1 leaves it unchanged and 0 mutes it
 }
 
 func getDefaultPcmOptions(ffmpegPath string) PcmOptions {
@@ -22,6 +23,7 @@ func getDefaultPcmOptions(ffmpegPath string) PcmOptions {
 		SampleRate: 48000, // Discord sample rate
 		Seek:       0,
 		Duration:   0,
+		Volume:     1,
 	}
 }
 
@@ -48,8 +50,12 @@ func getPcm(input string, opts PcmOptions) (io.ReadCloser, *exec.Cmd, error) {
 		cmdOpts = append(cmdOpts,
 			"-t", strconv.FormatFloat(float64(opts.Duration), 'f', 5, 32))
 	}
+	cmdOpts = append(cmdOpts, "-i", input)
+	if opts.Volume != 1.0 {
+		cmdOpts = append(cmdOpts,
+			"-af", "volume="+strconv.FormatFloat(float64(opts.Volume), 'f', 2, 32))
+	}
 	cmdOpts = append(cmdOpts, []string{
-		"-i", input,
 		"-f", "s16le", // Signed int16 samples.
 		"-ar", strconv.Itoa(opts.SampleRate),
 		"-ac", strconv.Itoa(opts.Channels), // Number of audio channels.
@@ -5,6 +5,7 @@ go 1.19
 require (
 	github.com/Pauloo27/searchtube v0.0.0-20220521202404-f65e288832a0
 	github.com/bwmarrin/discordgo v0.27.1
+	go.etcd.io/bbolt v1.3.7
 	layeh.com/gopus v0.0.0-20210501142526-1ee02d434e32
 )
 
@@ -12,5 +13,5 @@ require (
 	github.com/buger/jsonparser v1.1.1 // indirect
 	github.com/gorilla/websocket v1.4.2 // indirect
 	golang.org/x/crypto v0.0.0-20210421170649-83a5a9bb288b // indirect
-	golang.org/x/sys v0.0.0-20201119102817-f84b799fce68 // indirect
+	golang.org/x/sys v0.4.0 // indirect
 )
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"log"
+
+	dgo "github.com/bwmarrin/discordgo"
+)
+
+// Whether the client operates in guildId
+func (c *Client) Allowed(guildId string) bool {
+	return len(c.allowed) == 0 || c.allowed[guildId]
+}
+
+// The playback of guildId, created on first use if the guild is allowed
+func (c *Client) Playback(guildId string) (*Playback, bool) {
+	c.mu.RLock()
+	p, ok := c.players[guildId]
+	c.mu.RUnlock()
+	if ok || guildId == "" || !c.Allowed(guildId) {
+		return p, ok
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, ok := c.players[guildId]; ok {
+		return p, true
+	}
+
+	p = c.newPlayback(guildId)
+	c.players[guildId] = p
+	p.applySettings(c.Settings.Get(guildId), true)
+	log.Println("Created player for guild:", guildId)
+	return p, true
+}
+
+// A snapshot of the playbacks created so far
+func (c *Client) Playbacks() []*Playback {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	playbacks := make([]*Playback, 0, len(c.players))
+	for _, p := range c.players {
+		playbacks = append(playbacks, p)
+	}
+	return playbacks
+}
+
+// The channel of guildId the bot was last used from, empty if unknown
+func (c *Client) ActiveChannel(guildId string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.activeChannels[guildId]
+}
+
+func (c *Client) SetActiveChannel(guildId, channelId string) {
+	c.mu.Lock()
+	c.activeChannels[guildId] = channelId
+	c.mu.Unlock()
+}
+
+// The allowed guilds the bot is currently a member of
+func (c *Client) GuildIDs() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	guildIds := make([]string, 0, len(c.guilds))
+	for guildId := range c.guilds {
+		guildIds = append(guildIds, guildId)
+	}
+	return guildIds
+}
+
+// Tracks a guild the bot joined or that became available, registering the
+// commands in it, or clearing them from it when they're global.
+func (c *Client) OnGuildCreate(commands []*dgo.ApplicationCommand, global bool) func(*dgo.Session, *dgo.GuildCreate) {
+	return func(s *dgo.Session, g *dgo.GuildCreate) {
+		if !c.Allowed(g.ID) {
+			log.Printf("Ignoring guild %s (%s), it's not in the allowed guilds\n", g.ID, g.Name)
+			return
+		}
+
+		c.mu.Lock()
+		known := c.guilds[g.ID]
+		c.guilds[g.ID] = true
+		c.mu.Unlock()
+		if known {
+			return
+		}
+
+		log.Printf("Joined guild %s (%s)\n", g.ID, g.Name)
+		// Commands registered globally mustn't show up twice, the ones an
+		// earlier run registered in the guild get removed
+		guildCommands := commands
+		if global {
+			guildCommands = []*dgo.ApplicationCommand{}
+		}
+		if err := SyncCommands(s, g.ID, guildCommands); err != nil {
+			log.Println("[COMMANDS_ERR]:", err)
+		}
+	}
+}
+
+// Tears down the playback of a guild the bot got removed from. Guilds going
+// unavailable because of an outage are kept.
+func (c *Client) OnGuildDelete(s *dgo.Session, g *dgo.GuildDelete) {
+	if g.Unavailable {
+		log.Printf("Guild %s became unavailable\n", g.ID)
+		return
+	}
+
+	c.mu.Lock()
+	p, ok := c.players[g.ID]
+	delete(c.players, g.ID)
+	delete(c.activeChannels, g.ID)
+	delete(c.guilds, g.ID)
+	c.mu.Unlock()
+
+	log.Printf("Removed from guild %s\n", g.ID)
+	if !ok {
+		return
+	}
+
+	c.releaseVoice(s, p)
+	if c.Store != nil {
+		if err := c.Store.Delete(STORE_BUCKET_PLAYBACKS, g.ID); err != nil {
+			log.Printf("[STORE_ERR]: failed deleting playback of guild %s: %v\n", g.ID, err)
+		}
+	}
+}
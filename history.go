@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	dgo "github.com/bwmarrin/discordgo"
+)
+
+const (
+	HISTORY_SIZE          = 50
+	HISTORY_LIST_SIZE     = 15
+	HISTORY_EMPTY_ERR     = "Nothing has been played yet"
+	MAX_AUTOCOMPLETE_SIZE = 25
+)
+
+type HistoryEntry struct {
+	Track
+	StartedAt time.Time
+	EndedAt   time.Time // Zero while the track is still playing
+}
+
+// Fixed size ring buffer of the tracks played in a guild
+type History struct {
+	mu      sync.Mutex
+	entries []HistoryEntry
+	next    int
+	full    bool
+}
+
+func NewHistory(size int) *History {
+	return &History{
+		entries: make([]HistoryEntry, size),
+	}
+}
+
+// Records track as started now, overwriting the oldest entry once full
+func (h *History) Record(track Track) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.entries[h.next] = HistoryEntry{
+		Track:     track,
+		StartedAt: time.Now(),
+	}
+	h.next = (h.next + 1) % len(h.entries)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// Marks the latest entry as ended now
+func (h *History) Finish() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	last := (h.next - 1 + len(h.entries)) % len(h.entries)
+	if (h.full || h.next > 0) && h.entries[last].EndedAt.IsZero() {
+		h.entries[last].EndedAt = time.Now()
+	}
+}
+
+// Up to n entries, newest first
+func (h *History) Recent(n int) []HistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	count := h.next
+	if h.full {
+		count = len(h.entries)
+	}
+	if n > count {
+		n = count
+	}
+
+	recent := make([]HistoryEntry, 0, n)
+	for idx := 1; idx <= n; idx++ {
+		recent = append(recent, h.entries[(h.next-idx+len(h.entries))%len(h.entries)])
+	}
+	return recent
+}
+
+// The track played before the current one. When nothing is playing anymore
+// the latest played track is the previous one.
+func (p *Playback) PreviousTrack() (Track, bool) {
+	recent := p.History.Recent(2)
+	if len(recent) > 0 && !recent[0].EndedAt.IsZero() {
+		return recent[0].Track, true
+	}
+	if len(recent) > 1 {
+		return recent[1].Track, true
+	}
+	return Track{}, false
+}
+
+func (c *Client) PreviousCommand(s *dgo.Session, i *dgo.InteractionCreate) {
+	playback, ok := c.deferredPlayback(s, i)
+	if !ok {
+		return
+	}
+
+	previous, ok := playback.PreviousTrack()
+	if !ok {
+		InteractionErrorUpdate(s, i, HISTORY_EMPTY_ERR)
+		return
+	}
+
+	voiceConnection, err := JoinUserVoiceChannel(s, i.GuildID, i.Member.User.ID)
+	if err != nil {
+		InteractionErrorUpdate(s, i, JOIN_CHANNEL_ERR)
+		return
+	}
+
+	track, err := RefreshTrack(previous)
+	if err != nil {
+		log.Printf("Failed resolving previous track in guild %s, error: %s", i.GuildID, err)
+		InteractionErrorUpdate(s, i, BAD_COMMAND_ARG_ERR)
+		return
+	}
+
+	playback.setVoiceConnection(voiceConnection)
+	track.Requester = i.Member.User.Username
+	track.RequesterID = i.Member.User.ID
+	playback.Queue.Insert(0, track)
+
+	c.Podcasts.Snapshot(playback)
+	if _, ok := playback.Skip(); !ok {
+		InteractionErrorUpdate(s, i, QUEUE_EMPTY_ERR)
+		return
+	}
+
+	InteractionMessageUpdate(s, i, playback.NowPlayingMessage(track))
+}
+
+func (c *Client) HistoryCommand(s *dgo.Session, i *dgo.InteractionCreate) {
+	playback, ok := c.deferredPlayback(s, i)
+	if !ok {
+		return
+	}
+
+	recent := playback.History.Recent(HISTORY_LIST_SIZE)
+	if len(recent) == 0 {
+		InteractionErrorUpdate(s, i, HISTORY_EMPTY_ERR)
+		return
+	}
+
+	var sb strings.Builder
+	for idx, entry := range recent {
+		when := "playing now"
+		if !entry.EndedAt.IsZero() {
+			when = fmt.Sprintf("<t:%d:R>", entry.StartedAt.Unix())
+		}
+		sb.WriteString(fmt.Sprintf("`%d.` %s • %s\n", idx+1, trackDetails(entry.Track), when))
+	}
+
+	embed := &dgo.MessageEmbed{
+		Title:       "Recently played",
+		Description: sb.String(),
+		Color:       QUEUE_EMBED_COLOR,
+	}
+	_, err := s.InteractionResponseEdit(i.Interaction, &dgo.WebhookEdit{
+		Embeds: &[]*dgo.MessageEmbed{embed},
+	})
+	if err != nil {
+		log.Printf("Failed sending history message to guild %s, error: %s", i.GuildID, err)
+	}
+}
+
+// Suggests recently played tracks matching what's being typed as /play input
+func (c *Client) PlayAutocomplete(s *dgo.Session, i *dgo.InteractionCreate) {
+	typed := ""
+	for _, opt := range i.ApplicationCommandData().Options {
+		if opt.Name == "input" && opt.Focused {
+			typed = strings.ToLower(opt.StringValue())
+		}
+	}
+
+	choices := make([]*dgo.ApplicationCommandOptionChoice, 0, MAX_AUTOCOMPLETE_SIZE)
+	seen := make(map[string]bool)
+	if playback, ok := c.Playback(i.GuildID); ok {
+		for _, entry := range playback.History.Recent(HISTORY_SIZE) {
+			key := entry.SourceKey()
+			if len(choices) == MAX_AUTOCOMPLETE_SIZE {
+				break
+			}
+			// Values longer than 100 characters are refused by discord
+			if seen[key] || !strings.HasPrefix(entry.WebURL, "http") || len(entry.WebURL) > 100 {
+				continue
+			}
+			if typed != "" && !strings.Contains(strings.ToLower(entry.Title), typed) {
+				continue
+			}
+
+			seen[key] = true
+			choices = append(choices, &dgo.ApplicationCommandOptionChoice{
+				Name:  truncate("↺ "+entry.Title, 100),
+				Value: entry.WebURL,
+			})
+		}
+	}
+
+	err := s.InteractionRespond(i.Interaction, &dgo.InteractionResponse{
+		Type: dgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &dgo.InteractionResponseData{
+			Choices: choices,
+		},
+	})
+	if err != nil {
+		log.Printf("Failed sending autocomplete choices to guild %s, error: %s", i.GuildID, err)
+	}
+}
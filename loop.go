@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	dgo "github.com/bwmarrin/discordgo"
+)
+
+type LoopMode int
+
+const (
+	LoopOff LoopMode = iota
+	LoopTrack
+	LoopQueue
+)
+
+func (lm LoopMode) String() string {
+	switch lm {
+	case LoopOff:
+		return "off"
+	case LoopTrack:
+		return "track"
+	case LoopQueue:
+		return "queue"
+	}
+
+	panic("unreachable!")
+}
+
+func ParseLoopMode(mode string) (LoopMode, error) {
+	switch mode {
+	case "off":
+		return LoopOff, nil
+	case "track":
+		return LoopTrack, nil
+	case "queue":
+		return LoopQueue, nil
+	}
+	return LoopOff, fmt.Errorf("unknown loop mode: %s", mode)
+}
+
+// Decides what plays after finished, according to the loop mode. stopped
+// tells whether the track got interrupted rather than played until its end.
+func (p *Playback) nextAfter(finished Track, stopped bool) (Track, bool) {
+	finished.Seek = 0
+
+	switch p.Loop {
+	case LoopTrack:
+		if !stopped {
+			return finished, true
+		}
+	case LoopQueue:
+		p.Queue.Append(finished)
+	}
+
+	return p.Queue.Pop()
+}
+
+// The message announcing track, mentioning the loop mode if there's one
+func (p *Playback) NowPlayingMessage(track Track) string {
+	msg := fmt.Sprintf("Now playing %s | %s", track.Title, track.WebURL)
+	if p.Loop != LoopOff {
+		msg += fmt.Sprintf(" (looping %s)", p.Loop)
+	}
+	return msg
+}
+
+func (c *Client) LoopCommand(s *dgo.Session, i *dgo.InteractionCreate) {
+	playback, ok := c.deferredPlayback(s, i)
+	if !ok {
+		return
+	}
+
+	mode, err := ParseLoopMode(InteractionOptions(i).String("mode", ""))
+	if err != nil {
+		InteractionErrorUpdate(s, i, BAD_COMMAND_ARG_ERR)
+		return
+	}
+
+	c.setLoop(playback, mode)
+	InteractionMessageUpdate(s, i, fmt.Sprintf("Loop mode set to %s", mode))
+}
+
+// Makes p loop as mode says, remembering it for the guild so that it
+// outlives the playback
+func (c *Client) setLoop(p *Playback, mode LoopMode) {
+	p.Loop = mode
+	p.queueChanged()
+
+	err := c.Settings.Update(p.GuildID, func(settings *GuildSettings) {
+		settings.Loop = mode
+	})
+	if err != nil {
+		log.Printf("[STORE_ERR]: failed saving the loop mode of guild %s: %v\n", p.GuildID, err)
+	}
+}
@@ -14,73 +14,6 @@ import (
 
 var RemoveCommands bool = false
 
-const (
-	ALIVE_COMMAND_NAME  = "alive"
-	PLAY_COMMAND_NAME   = "play"
-	NEXT_COMMAND_NAME   = "next"
-	SKIP_COMMAND_NAME   = "skip" // Alias for /next
-	STOP_COMMAND_NAME   = "stop"
-	PAUSE_COMMAND_NAME  = "pause"
-	RESUME_COMMAND_NAME = "resume"
-	SEEK_COMMAND_NAME   = "ff"
-	LEAVE_COMMAND_NAME  = "leave"
-)
-
-var commands = []*dgo.ApplicationCommand{
-	{
-		Name:        PLAY_COMMAND_NAME,
-		Description: "Plays a song",
-		Options: []*dgo.ApplicationCommandOption{
-			{
-				Name:        "input",
-				Type:        dgo.ApplicationCommandOptionString,
-				Description: "Raw media URL | YT web url | YT searchbar",
-				Required:    true,
-			},
-		},
-	},
-	{
-		Name:        SEEK_COMMAND_NAME,
-		Description: "Fast forwards a song by a certain amount of seconds",
-		Options: []*dgo.ApplicationCommandOption{
-			{
-				Name:        "input",
-				Type:        dgo.ApplicationCommandOptionInteger,
-				Description: "Amount of seconds to skip",
-				Required:    true,
-			},
-		},
-	},
-	{
-		Name:        STOP_COMMAND_NAME,
-		Description: "Stops the current song",
-	},
-	{
-		Name:        NEXT_COMMAND_NAME,
-		Description: "Play the next song immediately",
-	},
-	{
-		Name:        SKIP_COMMAND_NAME,
-		Description: "Play the next song immediately",
-	},
-	{
-		Name:        RESUME_COMMAND_NAME,
-		Description: "Resumes a paused song",
-	},
-	{
-		Name:        RESUME_COMMAND_NAME,
-		Description: "Pauses a playing song",
-	},
-	{
-		Name:        ALIVE_COMMAND_NAME,
-		Description: "Am I alive? o.O",
-	},
-	{
-		Name:        LEAVE_COMMAND_NAME,
-		Description: "I'll leave the channel :(",
-	},
-}
-
 func main() {
 	userHome, err := os.UserHomeDir()
 	if err != nil {
@@ -96,7 +29,7 @@ func main() {
 	ytdlpPath := flags.String(
 		"ytdlp",
 		userHome+"/.local/bin/yt-dlp",
-		"Path to ffmpeg executable",
+		"Path to yt-dlp executable",
 	)
 	token := flags.String(
 		"token",
@@ -106,7 +39,7 @@ func main() {
 	guildsStr := flags.String(
 		"guilds",
 		"",
-		"A list of guild id for every discord server the bot will operate (comma separated)",
+		"A list of guild id the bot is allowed to operate in (comma separated), every guild it's a member of when empty",
 	)
 	logStatePtr := flags.Int(
 		"log-state",
@@ -118,6 +51,97 @@ func main() {
 		0,
 		"Log each player error events",
 	)
+	ytdlpUpdateHoursPtr := flags.Int(
+		"ytdlp-update-interval",
+		24,
+		"Hours between yt-dlp self updates (0 disables scheduled updates)",
+	)
+	ytdlpUpdateFailuresPtr := flags.Int(
+		"ytdlp-update-failures",
+		3,
+		"Update yt-dlp after this many consecutive extraction failures (0 disables)",
+	)
+	resolveWorkersPtr := flags.Int(
+		"resolve-workers",
+		4,
+		"Maximum amount of concurrent audio source resolutions",
+	)
+	resolveTimeoutPtr := flags.Int(
+		"resolve-timeout",
+		30,
+		"Seconds after which an audio source resolution is aborted (0 disables)",
+	)
+	ttsPath := flags.String(
+		"tts",
+		"",
+		"Path to the text to speech engine executable (empty disables /say)",
+	)
+	ttsEngine := flags.String(
+		"tts-engine",
+		TTS_ENGINE_ESPEAK,
+		"Text to speech engine, either espeak or piper",
+	)
+	ttsModel := flags.String(
+		"tts-voice",
+		"",
+		"Voice used by the text to speech engine (model path for piper)",
+	)
+	announceTracksPtr := flags.Bool(
+		"announce-tracks",
+		false,
+		"Announce each track title with text to speech before it plays",
+	)
+	dbPath := flags.String(
+		"db",
+		userHome+"/.godmb.db",
+		"Path to the database file keeping state across restarts (empty disables it)",
+	)
+	resumePtr := flags.Bool(
+		"resume",
+		true,
+		"Rejoin voice channels and resume playback saved before the last shutdown",
+	)
+	fairQueuePtr := flags.Bool(
+		"fair-queue",
+		false,
+		"Interleave queued tracks round-robin by requester instead of appending them",
+	)
+	maxUserTracksPtr := flags.Int(
+		"max-user-tracks",
+		0,
+		"Maximum amount of tracks a single user can have queued (0 disables)",
+	)
+	maxUserMinutesPtr := flags.Int(
+		"max-user-duration",
+		0,
+		"Maximum total minutes of tracks a single user can have queued (0 disables)",
+	)
+	voteSkipPtr := flags.Float64(
+		"vote-skip",
+		0,
+		"Fraction of the voice channel listeners needed to skip a track (0 lets anyone skip)",
+	)
+	djRole := flags.String(
+		"dj-role",
+		"",
+		"Name or id of the default DJ role, which skips without voting (overridden by /config permissions)",
+	)
+	globalCommandsPtr := flags.Bool(
+		"global-commands",
+		false,
+		"Register commands globally instead of in each guild (global changes can take a while to show up)",
+	)
+	flags.BoolVar(
+		&RemoveCommands,
+		"remove-commands",
+		false,
+		"Remove every command registration on exit",
+	)
+	removeCommandsNowPtr := flags.Bool(
+		"remove-commands-now",
+		false,
+		"Remove every command registration and exit right away",
+	)
 	if err := flags.Parse(os.Args[1:]); err != nil {
 		log.Fatal(err)
 	}
@@ -130,116 +154,162 @@ func main() {
 		os.Exit(1)
 	}
 
-	if *guildsStr == "" {
-		fmt.Printf("Failed parsing bot guilds\n\n")
-		if err := flags.Parse([]string{"-h"}); err != nil {
-			log.Fatal(err)
+	guilds := make([]string, 0)
+	for _, guildId := range strings.Split(strings.ReplaceAll(*guildsStr, " ", ""), ",") {
+		if guildId != "" {
+			guilds = append(guilds, guildId)
 		}
-		os.Exit(1)
 	}
 
-	guilds := strings.Split(
-		strings.ReplaceAll(*guildsStr, " ", ""),
-		",",
-	)
-
-	if len(guilds) == 0 {
-		fmt.Printf("Failed parsing bot guilds\n\n")
-		if err := flags.Parse([]string{"-h"}); err != nil {
+	SetFfmpegPath(*ffmpegPath)
+	SetYtdlpPath(*ytdlpPath)
+	if *ttsPath != "" {
+		synthesizer, err := NewSpeechSynthesizer(*ttsEngine, *ttsPath, *ttsModel)
+		if err != nil {
 			log.Fatal(err)
 		}
-		os.Exit(1)
+		SetSpeechSynthesizer(synthesizer, *announceTracksPtr)
 	}
-
-	SetFfmpegPath(*ffmpegPath)
-	SetYtdlpPath(*ytdlpPath)
+	SetResolverLimits(*resolveWorkersPtr, time.Duration(*resolveTimeoutPtr)*time.Second)
+	FairQueue = *fairQueuePtr
+	DefaultRequesterLimits = RequesterLimits{
+		MaxTracks:   *maxUserTracksPtr,
+		MaxDuration: *maxUserMinutesPtr * 60,
+	}
+	VoteSkipFraction = *voteSkipPtr
+	DJRole = *djRole
 
 	s, err := dgo.New("Bot " + *token)
 	if err != nil {
 		panic(err)
 	}
 
-	client := NewClient(s, guilds)
+	var store *Store
+	if *dbPath != "" {
+		store, err = OpenStore(*dbPath)
+		if err != nil {
+			log.Fatalf("Cannot open the database at %s: %v", *dbPath, err)
+		}
+		defer store.Close()
+	}
+
+	client := NewClient(s, guilds, store)
 
 	s.AddHandler(func(s *dgo.Session, r *dgo.Ready) {
 		username := s.State.User.Username + "#" + s.State.User.Discriminator
 		log.Println("Logged in as: ", username)
 	})
 
-	s.AddHandler(func(s *dgo.Session, i *dgo.InteractionCreate) {
-		commandName := i.ApplicationCommandData().Name
-		log.Printf("User %s from channel %s invoked command: %s\n", i.Member.User.Username, i.GuildID, commandName)
+	registry := client.CommandRegistry()
+	if err := registry.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	router := NewRouter()
 
+	router.Use(func(s *dgo.Session, i *dgo.InteractionCreate, route string) bool {
+		if i.Member == nil {
+			log.Printf("Ignoring interaction %s sent outside of a guild\n", route)
+			return false
+		}
+		if i.Type == dgo.InteractionApplicationCommandAutocomplete {
+			return true
+		}
+
+		log.Printf("User %s from channel %s used interaction: %s\n", i.Member.User.Username, i.GuildID, route)
 		// Update last active channel for this guild
-		client.ActiveChannels[i.GuildID] = i.ChannelID
-
-		switch commandName {
-		case ALIVE_COMMAND_NAME:
-			client.AliveCommand(s, i)
-		case PLAY_COMMAND_NAME:
-			client.PlayCommand(s, i)
-
-		case NEXT_COMMAND_NAME:
-			client.NextCommand(s, i)
-		case SKIP_COMMAND_NAME:
-			client.NextCommand(s, i)
-
-		case STOP_COMMAND_NAME:
-			client.StopCommand(s, i)
-		case PAUSE_COMMAND_NAME:
-			client.PauseCommand(s, i)
-		case RESUME_COMMAND_NAME:
-			client.ResumeCommand(s, i)
-		case SEEK_COMMAND_NAME:
-			client.SeekCommand(s, i)
-		case LEAVE_COMMAND_NAME:
-			client.LeaveCommand(s, i)
-		default:
-			log.Printf("%s no such command: %s\n", i.GuildID, commandName)
+		client.SetActiveChannel(i.GuildID, i.ChannelID)
+		return true
+	})
+
+	router.Use(func(s *dgo.Session, i *dgo.InteractionCreate, route string) bool {
+		if i.Type != dgo.InteractionApplicationCommand || client.Permitted(s, i.GuildID, i.Member, route) {
+			return true
 		}
+		InteractionEphemeralRespond(s, i, PERMISSION_DENIED_ERR)
+		return false
 	})
 
+	registry.Route(router)
+
+	router.Component(PODCAST_SELECT_ID, client.PodcastSelectComponent)
+	router.Component(QUEUE_PAGE_ID, client.QueuePageComponent)
+	router.Component(NOW_PLAYING_ID, client.NowPlayingComponent)
+	router.Component(VOTE_SKIP_ID, client.VoteSkipComponent)
+
+	s.AddHandler(router.Handle)
+	s.AddHandler(client.OnGuildDelete)
+	s.AddHandler(client.OnVoiceStateUpdate)
+	if !*removeCommandsNowPtr {
+		s.AddHandler(client.OnGuildCreate(registry.ApplicationCommands(), *globalCommandsPtr))
+	}
+
 	err = s.Open()
 	if err != nil {
 		log.Fatalf("Cannot open the session: %v", err)
 	}
 
-	for _, guildId := range guilds {
-		log.Println("Adding commands for guildId:", guildId)
-		registeredCommands := make([]*dgo.ApplicationCommand, len(commands))
-		for i, v := range commands {
-			cmd, err := s.ApplicationCommandCreate(s.State.User.ID, guildId, v)
-			log.Println("Created command", v.Name, "in", guildId)
-			if err != nil {
-				log.Panicf("Cannot create '%v' command: %v", v.Name, err)
+	defer s.Close()
+
+	if *removeCommandsNowPtr {
+		log.Println("Removing every command registration")
+		// Guilds are only listed as unavailable by the ready event
+		guildIds := make([]string, 0, len(s.State.Guilds))
+		for _, guild := range s.State.Guilds {
+			if client.Allowed(guild.ID) {
+				guildIds = append(guildIds, guild.ID)
 			}
-			registeredCommands[i] = cmd
 		}
+		RemoveAllCommands(s, guildIds)
+		return
 	}
 
-	defer s.Close()
+	// Guild commands are registered as guilds get created, see OnGuildCreate
+	if err := RegisterCommands(s, nil, registry.ApplicationCommands(), *globalCommandsPtr); err != nil {
+		log.Fatalf("Cannot register commands: %v", err)
+	}
+	if RemoveCommands {
+		defer func() {
+			RemoveAllCommands(s, client.GuildIDs())
+		}()
+	}
+
+	client.RestorePlaybacks(s, *resumePtr)
+	client.JoinStayChannels(s)
 
 	stoppingChannels := make([]chan struct{}, 0)
 
+	// Stopped first, so that the playbacks get saved before the bot leaves
+	// the voice channels
+	saverStop := client.StartPlaybackSaver(15)
+	stoppingChannels = append(stoppingChannels, saverStop)
+
 	timerStop := client.StartDisconnectionTimmer(s, 10)
 	stoppingChannels = append(stoppingChannels, timerStop)
 
+	podcastTrackerStop := client.StartPodcastTracker(15)
+	stoppingChannels = append(stoppingChannels, podcastTrackerStop)
+
+	nowPlayingStop := client.StartNowPlayingUpdater(s, 10)
+	stoppingChannels = append(stoppingChannels, nowPlayingStop)
+
+	watchdogStop := client.StartVoiceWatchdog(s, 5)
+	stoppingChannels = append(stoppingChannels, watchdogStop)
+
 	if *logStatePtr != 0 {
 		loggerStop := client.ClientLogger(func() string {
 			out := ""
-			for guildId := range client.ActiveChannels {
-				for _, player := range client.Players {
-					if player.voiceConnection.GuildID == guildId {
-						playerState := player.Player.State.String()
-						out = fmt.Sprintf(
-							"player state %s at guildId %s",
-							playerState,
-							guildId,
-						)
-					}
+			for _, player := range client.Playbacks() {
+				if player.VoiceConnection() != nil {
+					playerState := player.Player.State.String()
+					out = fmt.Sprintf(
+						"player state %s at guildId %s",
+						playerState,
+						player.GuildID,
+					)
 				}
 			}
-			return out
+			return out + " | " + DefaultResolver.Stats().String()
 		}, *logStatePtr)
 		stoppingChannels = append(stoppingChannels, loggerStop)
 	}
@@ -249,21 +319,14 @@ func main() {
 		stoppingChannels = append(stoppingChannels, loggerStop)
 	}
 
-	// Start update watcher process
-	go func() {
-		stopUpdateWatcher := make(chan struct{})
-		stoppingChannels = append(stoppingChannels, stopUpdateWatcher)
-		for {
-			select {
-			case <-stopUpdateWatcher:
-				break
-			default:
-			}
-			time.Sleep(1 * time.Minute)
-		}
-	}()
+	updaterStop := StartYTDLPUpdater(
+		time.Duration(*ytdlpUpdateHoursPtr)*time.Hour,
+		*ytdlpUpdateFailuresPtr,
+	)
+	stoppingChannels = append(stoppingChannels, updaterStop)
 
 	defer func() {
+		client.BeginShutdown()
 		for _, stoppingChannel := range stoppingChannels {
 			stoppingChannel <- struct{}{}
 		}
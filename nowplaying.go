@@ -0,0 +1,344 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"ndmb/enc"
+
+	dgo "github.com/bwmarrin/discordgo"
+)
+
+const (
+	NOW_PLAYING_ID          = "now_playing:"
+	NOW_PLAYING_BAR_SIZE    = 20
+	NOW_PLAYING_MIN_EDIT    = 3 * time.Second
+	NOW_PLAYING_EMBED_COLOR = 0x1db954
+)
+
+// The message showing what a guild is playing, kept up to date in place
+type NowPlayingView struct {
+	ChannelID string
+	MessageID string
+	editedAt  time.Time
+	pending   bool // Whether a throttled edit is already scheduled
+}
+
+// Renders position out of duration as a bar, position being the knob
+func progressBar(position, duration int) string {
+	if duration <= 0 {
+		return strings.Repeat("▬", NOW_PLAYING_BAR_SIZE)
+	}
+
+	knob := position * NOW_PLAYING_BAR_SIZE / duration
+	if knob >= NOW_PLAYING_BAR_SIZE {
+		knob = NOW_PLAYING_BAR_SIZE - 1
+	}
+	return strings.Repeat("▬", knob) + "🔘" + strings.Repeat("▬", NOW_PLAYING_BAR_SIZE-knob-1)
+}
+
+func trackThumbnail(track Track) string {
+	if id := YoutubeVideoID(track.WebURL); id != "" {
+		return "https://i.ytimg.com/vi/" + id + "/hqdefault.jpg"
+	}
+	return ""
+}
+
+// Must not be called from player event listeners, as it queries the player
+func (p *Playback) NowPlayingEmbed() (*dgo.MessageEmbed, []dgo.MessageComponent) {
+	state := p.Player.State
+	if state != enc.PlayerStatePlaying && state != enc.PlayerStatePaused {
+		return &dgo.MessageEmbed{
+			Title:       "Nothing is playing",
+			Description: "Use /play to start a track",
+			Color:       NOW_PLAYING_EMBED_COLOR,
+		}, []dgo.MessageComponent{}
+	}
+
+	track := p.Track
+	position, _ := p.Position()
+	progress := FormatDuration(int(position))
+	if track.Duration > 0 {
+		progress += " / " + FormatDuration(track.Duration)
+	}
+
+	title := "Now playing"
+	if state == enc.PlayerStatePaused {
+		title = "Paused"
+	}
+
+	requester := track.Requester
+	if track.Autoplayed {
+		requester = "autoplay"
+	}
+	if requester == "" {
+		requester = "unknown"
+	}
+
+	autoplay := "off"
+	if p.Autoplay {
+		autoplay = "on"
+	}
+
+	embed := &dgo.MessageEmbed{
+		Title:       title,
+		Description: fmt.Sprintf("%s\n\n%s `%s`", trackLink(track), progressBar(int(position), track.Duration), progress),
+		Color:       NOW_PLAYING_EMBED_COLOR,
+		Fields: []*dgo.MessageEmbedField{
+			{Name: "Requested by", Value: requester, Inline: true},
+			{Name: "Queue", Value: fmt.Sprintf("%d tracks", p.Queue.Len()), Inline: true},
+			{Name: "Loop", Value: p.Loop.String(), Inline: true},
+			{Name: "Autoplay", Value: autoplay, Inline: true},
+			{Name: "Volume", Value: fmt.Sprintf("%d%%", p.Volume), Inline: true},
+		},
+	}
+	if thumbnail := trackThumbnail(track); thumbnail != "" {
+		embed.Thumbnail = &dgo.MessageEmbedThumbnail{URL: thumbnail}
+	}
+
+	pauseLabel := "Pause"
+	if state == enc.PlayerStatePaused {
+		pauseLabel = "Resume"
+	}
+	components := []dgo.MessageComponent{
+		dgo.ActionsRow{
+			Components: []dgo.MessageComponent{
+				dgo.Button{Label: pauseLabel, Style: dgo.PrimaryButton, CustomID: NOW_PLAYING_ID + "pause"},
+				dgo.Button{Label: "Skip", Style: dgo.SecondaryButton, CustomID: NOW_PLAYING_ID + "skip"},
+				dgo.Button{Label: "Stop", Style: dgo.DangerButton, CustomID: NOW_PLAYING_ID + "stop"},
+				dgo.Button{Label: "Loop: " + p.Loop.String(), Style: dgo.SecondaryButton, CustomID: NOW_PLAYING_ID + "loop"},
+				dgo.Button{Label: "Shuffle", Style: dgo.SecondaryButton, CustomID: NOW_PLAYING_ID + "shuffle"},
+			},
+		},
+	}
+
+	return embed, components
+}
+
+// Edits the now playing message of p, at most once every NOW_PLAYING_MIN_EDIT.
+// A new message is sent to the last active channel when there's none yet and
+// something is playing.
+func (c *Client) RefreshNowPlaying(s *dgo.Session, p *Playback) {
+	p.nowPlayingLock.Lock()
+	defer p.nowPlayingLock.Unlock()
+
+	view := p.nowPlaying
+	if view == nil {
+		c.sendNowPlaying(s, p)
+		return
+	}
+
+	if wait := NOW_PLAYING_MIN_EDIT - time.Since(view.editedAt); wait > 0 {
+		if !view.pending {
+			view.pending = true
+			time.AfterFunc(wait, func() {
+				p.nowPlayingLock.Lock()
+				view.pending = false
+				p.nowPlayingLock.Unlock()
+				c.RefreshNowPlaying(s, p)
+			})
+		}
+		return
+	}
+
+	embed, components := p.NowPlayingEmbed()
+	view.editedAt = time.Now()
+	_, err := s.ChannelMessageEditComplex(&dgo.MessageEdit{
+		ID:         view.MessageID,
+		Channel:    view.ChannelID,
+		Embeds:     []*dgo.MessageEmbed{embed},
+		Components: components,
+	})
+	if err != nil {
+		log.Printf("Failed refreshing now playing message %s in channel %s, error: %s", view.MessageID, view.ChannelID, err)
+		// The message is probably gone, a new one gets sent next time
+		p.nowPlaying = nil
+	}
+}
+
+// Must be called with the now playing lock held
+func (c *Client) sendNowPlaying(s *dgo.Session, p *Playback) {
+	channelId := c.AnnounceChannel(p.GuildID)
+	if channelId == "" || p.Player.State != enc.PlayerStatePlaying {
+		return
+	}
+
+	embed, components := p.NowPlayingEmbed()
+	msg, err := s.ChannelMessageSendComplex(channelId, &dgo.MessageSend{
+		Embeds:     []*dgo.MessageEmbed{embed},
+		Components: components,
+	})
+	if err != nil {
+		log.Printf("Failed sending now playing message to channel %s, error: %s", channelId, err)
+		return
+	}
+
+	p.nowPlaying = &NowPlayingView{
+		ChannelID: channelId,
+		MessageID: msg.ID,
+		editedAt:  time.Now(),
+	}
+}
+
+// Periodically moves the progress bars of playing tracks forward
+func (c *Client) StartNowPlayingUpdater(s *dgo.Session, updateEvery int) chan struct{} {
+	stop := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(updateEvery) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			var wg sync.WaitGroup
+			for _, playback := range c.Playbacks() {
+				if playback.Player.State != enc.PlayerStatePlaying {
+					continue
+				}
+				wg.Add(1)
+				go func(p *Playback) {
+					defer wg.Done()
+					c.RefreshNowPlaying(s, p)
+				}(playback)
+			}
+			wg.Wait()
+		}
+	}()
+
+	return stop
+}
+
+// Sends the now playing message as the response, moving the tracked message
+// to this channel.
+func (c *Client) NowPlayingCommand(s *dgo.Session, i *dgo.InteractionCreate) {
+	playback, ok := c.deferredPlayback(s, i)
+	if !ok {
+		return
+	}
+
+	embed, components := playback.NowPlayingEmbed()
+	msg, err := s.InteractionResponseEdit(i.Interaction, &dgo.WebhookEdit{
+		Embeds:     &[]*dgo.MessageEmbed{embed},
+		Components: &components,
+	})
+	if err != nil {
+		log.Printf("Failed sending now playing message to guild %s, error: %s", i.GuildID, err)
+		return
+	}
+
+	playback.nowPlayingLock.Lock()
+	playback.nowPlaying = &NowPlayingView{
+		ChannelID: i.ChannelID,
+		MessageID: msg.ID,
+		editedAt:  time.Now(),
+	}
+	playback.nowPlayingLock.Unlock()
+}
+
+// Handles the now playing buttons, which are subject to the same permissions
+// as their respective commands.
+func (c *Client) NowPlayingComponent(s *dgo.Session, i *dgo.InteractionCreate) {
+	playback, ok := c.Playback(i.GuildID)
+	if !ok || playback.VoiceConnection() == nil {
+		InteractionEphemeralRespond(s, i, NO_VOICE_CONNECTION_ERR)
+		return
+	}
+
+	playing := playback.Player.State == enc.PlayerStatePlaying || playback.Player.State == enc.PlayerStatePaused
+	action := strings.TrimPrefix(i.MessageComponentData().CustomID, NOW_PLAYING_ID)
+
+	command := ""
+	switch action {
+	case "pause":
+		command = PAUSE_COMMAND_NAME
+		if playback.Player.State == enc.PlayerStatePaused {
+			command = RESUME_COMMAND_NAME
+		}
+	case "skip":
+		command = NEXT_COMMAND_NAME
+	case "stop":
+		command = STOP_COMMAND_NAME
+	case "loop":
+		command = LOOP_COMMAND_NAME
+	case "shuffle":
+		command = SHUFFLE_COMMAND_NAME
+	default:
+		InteractionEphemeralRespond(s, i, BAD_COMMAND_ARG_ERR)
+		return
+	}
+
+	if !c.Permitted(s, i.GuildID, i.Member, command) {
+		InteractionEphemeralRespond(s, i, PERMISSION_DENIED_ERR)
+		return
+	}
+	if !playing && command != LOOP_COMMAND_NAME && command != SHUFFLE_COMMAND_NAME {
+		InteractionEphemeralRespond(s, i, NO_TRACK_PLAYING_ERR)
+		return
+	}
+
+	switch command {
+	case PAUSE_COMMAND_NAME:
+		c.Podcasts.Snapshot(playback)
+		playback.CommandChannel <- enc.CommandPause{}
+	case RESUME_COMMAND_NAME:
+		playback.CommandChannel <- enc.CommandResume{}
+	case NEXT_COMMAND_NAME:
+		if playback.Queue.Len() == 0 {
+			InteractionEphemeralRespond(s, i, QUEUE_EMPTY_ERR)
+			return
+		}
+		if !c.CanSkip(s, playback, i.Member) {
+			c.nowPlayingVoteSkip(s, i, playback)
+			return
+		}
+		c.Podcasts.Snapshot(playback)
+		playback.Skip()
+	case STOP_COMMAND_NAME:
+		c.Podcasts.Snapshot(playback)
+		playback.Stop()
+	case LOOP_COMMAND_NAME:
+		c.setLoop(playback, (playback.Loop+1)%(LoopQueue+1))
+	case SHUFFLE_COMMAND_NAME:
+		playback.Queue.Shuffle()
+	}
+
+	// The message gets refreshed by the player events and queue listeners
+	err := s.InteractionRespond(i.Interaction, &dgo.InteractionResponse{
+		Type: dgo.InteractionResponseDeferredMessageUpdate,
+	})
+	if err != nil {
+		log.Printf("Failed acknowledging now playing button in guild %s, error: %s", i.GuildID, err)
+	}
+}
+
+// Starts or joins a vote to skip, in a message of its own everyone can vote in
+func (c *Client) nowPlayingVoteSkip(s *dgo.Session, i *dgo.InteractionCreate, playback *Playback) {
+	playback.skipVotesLock.Lock()
+	round := playback.skipVotes.Round
+	playback.skipVotesLock.Unlock()
+
+	msg, components, err := c.castSkipVote(s, i, playback, round)
+	if err != nil {
+		InteractionEphemeralRespond(s, i, err.Error())
+		return
+	}
+
+	err = s.InteractionRespond(i.Interaction, &dgo.InteractionResponse{
+		Type: dgo.InteractionResponseChannelMessageWithSource,
+		Data: &dgo.InteractionResponseData{
+			Content:    msg,
+			Components: components,
+		},
+	})
+	if err != nil {
+		log.Printf("Failed sending vote skip message to guild %s, error: %s", i.GuildID, err)
+	}
+}
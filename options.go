@@ -0,0 +1,98 @@
+package main
+
+import (
+	dgo "github.com/bwmarrin/discordgo"
+)
+
+// Command options by name, with typed getters falling back to a default
+// value when an option wasn't given.
+type CommandOptions map[string]*dgo.ApplicationCommandInteractionDataOption
+
+func NewCommandOptions(options []*dgo.ApplicationCommandInteractionDataOption) CommandOptions {
+	opts := make(CommandOptions, len(options))
+	for _, opt := range options {
+		opts[opt.Name] = opt
+	}
+	return opts
+}
+
+// The options of a command without subcommands
+func InteractionOptions(i *dgo.InteractionCreate) CommandOptions {
+	return NewCommandOptions(i.ApplicationCommandData().Options)
+}
+
+// The invoked subcommand and its options. Subcommand groups are joined to
+// their subcommand by a space, as in "permissions allow".
+func InteractionSubcommand(i *dgo.InteractionCreate) (string, CommandOptions) {
+	options := i.ApplicationCommandData().Options
+	name := ""
+	for len(options) == 1 && (options[0].Type == dgo.ApplicationCommandOptionSubCommandGroup ||
+		options[0].Type == dgo.ApplicationCommandOptionSubCommand) {
+		if name != "" {
+			name += " "
+		}
+		name += options[0].Name
+		options = options[0].Options
+	}
+	return name, NewCommandOptions(options)
+}
+
+func (o CommandOptions) Has(name string) bool {
+	_, ok := o[name]
+	return ok
+}
+
+func (o CommandOptions) String(name, fallback string) string {
+	if opt, ok := o[name]; ok {
+		if value, ok := opt.Value.(string); ok {
+			return value
+		}
+	}
+	return fallback
+}
+
+func (o CommandOptions) Int(name string, fallback int) int {
+	if opt, ok := o[name]; ok {
+		if value, ok := opt.Value.(float64); ok {
+			return int(value)
+		}
+	}
+	return fallback
+}
+
+func (o CommandOptions) Float(name string, fallback float64) float64 {
+	if opt, ok := o[name]; ok {
+		if value, ok := opt.Value.(float64); ok {
+			return value
+		}
+	}
+	return fallback
+}
+
+func (o CommandOptions) Bool(name string, fallback bool) bool {
+	if opt, ok := o[name]; ok {
+		if value, ok := opt.Value.(bool); ok {
+			return value
+		}
+	}
+	return fallback
+}
+
+// The id held by a user, role, channel or attachment option
+func (o CommandOptions) ID(name string) string {
+	return o.String(name, "")
+}
+
+// The attachment given as option name, nil if there's none
+func (o CommandOptions) Attachment(i *dgo.InteractionCreate, name string) *dgo.MessageAttachment {
+	id := o.ID(name)
+	if id == "" {
+		return nil
+	}
+
+	resolved := i.ApplicationCommandData().Resolved
+	if resolved == nil {
+		return nil
+	}
+	return resolved.Attachments[id]
+}
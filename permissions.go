@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	dgo "github.com/bwmarrin/discordgo"
+)
+
+const (
+	STORE_BUCKET_PERMISSIONS = "permissions"
+
+	PERMISSION_DENIED_ERR = "You're not allowed to use this command"
+	CONFIG_DENIED_ERR     = "Only members who can manage the server can change the configuration"
+)
+
+// Who can use a restricted command. DJ lets in DJ role holders, as well as
+// anyone listening alone to the bot.
+type CommandPermission struct {
+	Roles []string
+	Users []string
+	DJ    bool
+}
+
+type GuildPermissions struct {
+	Commands map[string]CommandPermission
+}
+
+// Per guild permissions, cached in memory and persisted into the store
+type Permissions struct {
+	mu     sync.Mutex
+	store  *Store // Nil when persistence is disabled
+	guilds map[string]GuildPermissions
+}
+
+func NewPermissions(store *Store) *Permissions {
+	return &Permissions{
+		store:  store,
+		guilds: make(map[string]GuildPermissions),
+	}
+}
+
+// Must be called with the lock held
+func (pm *Permissions) load(guildId string) GuildPermissions {
+	if perms, ok := pm.guilds[guildId]; ok {
+		return perms
+	}
+
+	perms := GuildPermissions{}
+	if pm.store != nil {
+		if _, err := pm.store.Get(STORE_BUCKET_PERMISSIONS, guildId, &perms); err != nil {
+			log.Printf("[STORE_ERR]: failed loading permissions of guild %s: %v\n", guildId, err)
+		}
+	}
+	if perms.Commands == nil {
+		perms.Commands = make(map[string]CommandPermission)
+	}
+	pm.guilds[guildId] = perms
+	return perms
+}
+
+func (pm *Permissions) Get(guildId string) GuildPermissions {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.load(guildId)
+}
+
+// Applies change to the permissions of guildId and persists them
+func (pm *Permissions) Update(guildId string, change func(*GuildPermissions)) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	// Copied so that the maps handed out by Get are never written to
+	perms := pm.load(guildId)
+	commands := make(map[string]CommandPermission, len(perms.Commands))
+	for command, rule := range perms.Commands {
+		commands[command] = rule
+	}
+	perms.Commands = commands
+	change(&perms)
+	pm.guilds[guildId] = perms
+
+	if pm.store == nil {
+		return nil
+	}
+	return pm.store.Put(STORE_BUCKET_PERMISSIONS, guildId, perms)
+}
+
+func hasPermission(member *dgo.Member, permission int64) bool {
+	return member.Permissions&dgo.PermissionAdministrator != 0 || member.Permissions&permission != 0
+}
+
+// Whether member holds the DJ role of the guild, or is the only one listening
+// to the bot.
+func (c *Client) IsDJ(s *dgo.Session, guildId string, member *dgo.Member) bool {
+	if member == nil {
+		return false
+	}
+	if c.hasDJRole(s, guildId, member) {
+		return true
+	}
+
+	if playback, ok := c.Playback(guildId); ok {
+		if voiceConnection := playback.VoiceConnection(); voiceConnection != nil {
+			listeners := VoiceChannelListeners(s, guildId, voiceConnection.ChannelID)
+			return len(listeners) == 1 && listeners[0] == member.User.ID
+		}
+	}
+	return false
+}
+
+// Whether member holds the DJ role of the guild, given by id or name
+func (c *Client) hasDJRole(s *dgo.Session, guildId string, member *dgo.Member) bool {
+	djRole := c.guildDJRole(guildId)
+	if djRole == "" {
+		djRole = DJRole
+	}
+	if djRole == "" {
+		return false
+	}
+
+	for _, roleId := range member.Roles {
+		if roleId == djRole {
+			return true
+		}
+		if role, err := s.State.Role(guildId, roleId); err == nil && strings.EqualFold(role.Name, djRole) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether member is allowed to use command, server managers always are
+func (c *Client) Permitted(s *dgo.Session, guildId string, member *dgo.Member, command string) bool {
+	if command == SKIP_COMMAND_NAME {
+		command = NEXT_COMMAND_NAME
+	}
+
+	rule, restricted := c.Permissions.Get(guildId).Commands[command]
+	if !restricted || hasPermission(member, dgo.PermissionManageServer) {
+		return true
+	}
+
+	for _, userId := range rule.Users {
+		if userId == member.User.ID {
+			return true
+		}
+	}
+	for _, roleId := range rule.Roles {
+		for _, memberRole := range member.Roles {
+			if roleId == memberRole {
+				return true
+			}
+		}
+	}
+	return rule.DJ && c.IsDJ(s, guildId, member)
+}
+
+// The DJ role id configured in guildId, empty if there's none
+func (c *Client) guildDJRole(guildId string) string {
+	return c.Settings.Get(guildId).DJRole
+}
+
+func (c *Client) describeDJRole(guildId string) string {
+	if djRole := c.guildDJRole(guildId); djRole != "" {
+		return "<@&" + djRole + ">"
+	}
+	if DJRole != "" {
+		return DJRole
+	}
+	return "none, only solo listeners are DJs"
+}
+
+func appendMissing(ids []string, id string) []string {
+	for _, present := range ids {
+		if present == id {
+			return ids
+		}
+	}
+	return append(ids, id)
+}
+
+func (c *Client) ConfigCommand(s *dgo.Session, i *dgo.InteractionCreate) {
+	if !hasPermission(i.Member, dgo.PermissionManageServer) {
+		InteractionEphemeralRespond(s, i, CONFIG_DENIED_ERR)
+		return
+	}
+
+	if err := InteractionRespondDeferred(s, i); err != nil {
+		log.Printf(
+			"Failed sending deferred response into guild: %s, error: %s",
+			i.GuildID,
+			err,
+		)
+	}
+
+	subcommand, options := InteractionSubcommand(i)
+	group, subcommand, _ := strings.Cut(subcommand, " ")
+	switch group {
+	case "permissions":
+		c.configPermissions(s, i, subcommand, options)
+	case "idle":
+		c.configIdle(s, i, subcommand, options)
+	case "announce":
+		c.configAnnounce(s, i, subcommand, options)
+	case "get", "set", "reset":
+		c.configSettings(s, i, group, options)
+	default:
+		InteractionErrorUpdate(s, i, BAD_COMMAND_ARG_ERR)
+	}
+}
+
+func (c *Client) configPermissions(s *dgo.Session, i *dgo.InteractionCreate, subcommand string, options CommandOptions) {
+	var msg string
+	var change func(*GuildPermissions)
+	switch subcommand {
+	case "show":
+		InteractionSilentUpdate(s, i, c.describePermissions(i.GuildID))
+		return
+	case "dj-role":
+		roleId := options.ID("role")
+		err := c.Settings.Update(i.GuildID, func(settings *GuildSettings) {
+			settings.DJRole = roleId
+		})
+		if err != nil {
+			log.Printf("[STORE_ERR]: failed saving settings of guild %s: %v\n", i.GuildID, err)
+			InteractionErrorUpdate(s, i, NO_PLAYER_AVAILABLE_ERR)
+			return
+		}
+		msg = "DJ role cleared"
+		if roleId != "" {
+			msg = fmt.Sprintf("DJ role set to <@&%s>", roleId)
+		}
+		InteractionSilentUpdate(s, i, msg)
+		return
+	case "allow":
+		command := options.String("command", "")
+		roleId, userId := options.ID("role"), options.ID("user")
+		change = func(perms *GuildPermissions) {
+			rule := perms.Commands[command]
+			if roleId != "" {
+				rule.Roles = appendMissing(rule.Roles, roleId)
+			}
+			if userId != "" {
+				rule.Users = appendMissing(rule.Users, userId)
+			}
+			if roleId == "" && userId == "" {
+				rule.DJ = true
+			}
+			perms.Commands[command] = rule
+		}
+		msg = fmt.Sprintf("/%s is now restricted", command)
+	case "reset":
+		command := options.String("command", "")
+		change = func(perms *GuildPermissions) {
+			delete(perms.Commands, command)
+		}
+		msg = fmt.Sprintf("/%s is now open to everyone", command)
+	default:
+		InteractionErrorUpdate(s, i, BAD_COMMAND_ARG_ERR)
+		return
+	}
+
+	if err := c.Permissions.Update(i.GuildID, change); err != nil {
+		log.Printf("[STORE_ERR]: failed saving permissions of guild %s: %v\n", i.GuildID, err)
+		InteractionErrorUpdate(s, i, NO_PLAYER_AVAILABLE_ERR)
+		return
+	}
+	InteractionSilentUpdate(s, i, msg)
+}
+
+func (c *Client) describePermissions(guildId string) string {
+	perms := c.Permissions.Get(guildId)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("DJ role: %s\n", c.describeDJRole(guildId)))
+
+	commands := make([]string, 0, len(perms.Commands))
+	for command := range perms.Commands {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	if len(commands) == 0 {
+		sb.WriteString("Every command is open to everyone")
+	}
+
+	for _, command := range commands {
+		rule := perms.Commands[command]
+		allowed := make([]string, 0, len(rule.Roles)+len(rule.Users)+1)
+		if rule.DJ {
+			allowed = append(allowed, "DJs")
+		}
+		for _, roleId := range rule.Roles {
+			allowed = append(allowed, "<@&"+roleId+">")
+		}
+		for _, userId := range rule.Users {
+			allowed = append(allowed, "<@"+userId+">")
+		}
+		sb.WriteString(fmt.Sprintf("/%s: %s\n", command, strings.Join(allowed, ", ")))
+	}
+	return sb.String()
+}
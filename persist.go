@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"ndmb/enc"
+
+	dgo "github.com/bwmarrin/discordgo"
+)
+
+// Everything needed to pick up a guild playback after a restart
+type PlaybackSnapshot struct {
+	GuildID        string
+	VoiceChannelID string
+	Current        *Track
+	Position       float32
+	Queue          []Track
+	Loop           LoopMode
+	Autoplay       bool
+	SavedAt        time.Time
+}
+
+func (p *Playback) Snapshot() PlaybackSnapshot {
+	snapshot := PlaybackSnapshot{
+		GuildID:  p.GuildID,
+		Queue:    p.Queue.Tracks(),
+		Loop:     p.Loop,
+		Autoplay: p.Autoplay,
+		SavedAt:  time.Now(),
+	}
+
+	if voiceConnection := p.VoiceConnection(); voiceConnection != nil {
+		snapshot.VoiceChannelID = voiceConnection.ChannelID
+	}
+
+	if p.Player.State == enc.PlayerStatePlaying || p.Player.State == enc.PlayerStatePaused {
+		current := p.Track
+		snapshot.Current = &current
+		if position, ok := p.Position(); ok {
+			snapshot.Position = position
+		}
+	}
+
+	return snapshot
+}
+
+// Marks the client as stopping, the bot leaving the voice channels from then
+// on mustn't be saved.
+func (c *Client) BeginShutdown() {
+	c.mu.Lock()
+	c.shuttingDown = true
+	c.mu.Unlock()
+}
+
+func (c *Client) ShuttingDown() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.shuttingDown
+}
+
+func (c *Client) SavePlayback(p *Playback) {
+	if c.Store == nil {
+		return
+	}
+
+	if err := c.Store.Put(STORE_BUCKET_PLAYBACKS, p.GuildID, p.Snapshot()); err != nil {
+		log.Printf("[STORE_ERR]: failed saving playback of guild %s: %v\n", p.GuildID, err)
+	}
+}
+
+// Periodically saves every playback, so that positions stay fresh. Playbacks
+// get saved one last time when stopping.
+func (c *Client) StartPlaybackSaver(saveEvery int) chan struct{} {
+	stop := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(saveEvery) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				for _, playback := range c.Playbacks() {
+					c.SavePlayback(playback)
+				}
+				return
+			case <-ticker.C:
+			}
+
+			for _, playback := range c.Playbacks() {
+				if playback.Player.State == enc.PlayerStatePlaying {
+					c.SavePlayback(playback)
+				}
+			}
+		}
+	}()
+
+	return stop
+}
+
+// Restores the saved queues and, when resume is set, rejoins the voice
+// channels and continues the tracks from where they were left.
+func (c *Client) RestorePlaybacks(s *dgo.Session, resume bool) {
+	if c.Store == nil {
+		return
+	}
+
+	snapshots := make([]PlaybackSnapshot, 0)
+	err := c.Store.ForEach(STORE_BUCKET_PLAYBACKS, func(key string, value []byte) error {
+		var snapshot PlaybackSnapshot
+		if err := json.Unmarshal(value, &snapshot); err != nil {
+			log.Printf("[STORE_ERR]: skipping broken playback snapshot of guild %s: %v\n", key, err)
+			return nil
+		}
+		snapshots = append(snapshots, snapshot)
+		return nil
+	})
+	if err != nil {
+		log.Println("[STORE_ERR]: failed reading playback snapshots:", err)
+		return
+	}
+
+	for _, snapshot := range snapshots {
+		playback, ok := c.Playback(snapshot.GuildID)
+		if !ok {
+			continue
+		}
+
+		// Media urls are likely expired by now, resolve them once played
+		queue := make([]Track, 0, len(snapshot.Queue))
+		for _, track := range snapshot.Queue {
+			queue = append(queue, track.Unresolved())
+		}
+
+		playback.Loop = snapshot.Loop
+		playback.Autoplay = snapshot.Autoplay
+		playback.Queue.Push(queue...)
+		log.Printf("Restored %d queued tracks in guild %s\n", len(queue), snapshot.GuildID)
+
+		if !resume || snapshot.Current == nil || snapshot.VoiceChannelID == "" {
+			continue
+		}
+
+		// Joining and resolving take a while, guilds shouldn't wait on each other
+		go c.resumePlayback(s, playback, snapshot)
+	}
+}
+
+// Rejoins the voice channel of snapshot and continues its track
+func (c *Client) resumePlayback(s *dgo.Session, playback *Playback, snapshot PlaybackSnapshot) {
+	voiceConnection, err := s.ChannelVoiceJoin(snapshot.GuildID, snapshot.VoiceChannelID, false, true)
+	if err != nil {
+		log.Printf("Failed rejoining voice channel %s in guild %s: %v\n", snapshot.VoiceChannelID, snapshot.GuildID, err)
+		return
+	}
+
+	current := snapshot.Current.Unresolved()
+	current.Seek = snapshot.Position
+	playback.setVoiceConnection(voiceConnection)
+	playback.Play(current)
+	log.Printf("Resumed %s at %s in guild %s\n", current.Title, FormatDuration(int(current.Seek)), snapshot.GuildID)
+}
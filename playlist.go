@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"ndmb/enc"
+
+	dgo "github.com/bwmarrin/discordgo"
+)
+
+const (
+	STORE_BUCKET_PLAYLISTS = "playlists"
+
+	PLAYLIST_SCOPE_USER  = "user"
+	PLAYLIST_SCOPE_GUILD = "guild"
+
+	MAX_PLAYLIST_ENTRIES     = 500
+	MAX_PLAYLIST_NAME        = 50
+	MAX_PLAYLIST_IMPORT_SIZE = 1024 * 1024
+
+	PLAYLIST_NO_STORE_ERR  = "Playlists are not available, the database is disabled"
+	PLAYLIST_NOT_FOUND_ERR = "There's no playlist with that name"
+	PLAYLIST_EXISTS_ERR    = "A playlist with that name already exists"
+	PLAYLIST_NAME_ERR      = "Playlist names must be between 1 and 50 characters long"
+	PLAYLIST_FULL_ERR      = "The playlist is full"
+	PLAYLIST_EMPTY_ERR     = "The playlist is empty"
+	PLAYLIST_IMPORT_ERR    = "Couldn't read any track from the given file"
+	PLAYLIST_POSITION_ERR  = "There's no track at that position in the playlist"
+	PLAYLIST_ENTRY_URL_ERR = "Playlists can only hold http and https links"
+	PLAYLIST_GUILD_ERR     = "Only DJs and members who can manage the server can change server playlists"
+)
+
+var ErrPlaylistEntryURL = errors.New("playlist entry isn't an http or https link")
+
+type PlaylistEntry struct {
+	Title    string
+	URL      string
+	Duration int // Seconds, 0 if unknown
+}
+
+// A named list of tracks owned either by a user or by a whole guild
+type Playlist struct {
+	Name      string
+	Scope     string
+	OwnerID   string
+	Entries   []PlaylistEntry
+	UpdatedAt time.Time
+}
+
+func NewPlaylistEntry(track Track) PlaylistEntry {
+	return PlaylistEntry{
+		Title:    track.Title,
+		URL:      track.SourceKey(),
+		Duration: track.Duration,
+	}
+}
+
+// Reports entries not pointing to an http or https link, anything else could
+// get ffmpeg reading local files.
+func (e PlaylistEntry) Check() error {
+	u, err := url.Parse(e.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrPlaylistEntryURL
+	}
+	return nil
+}
+
+// The track to be played for entry, resolved lazily if it can be
+func (e PlaylistEntry) Track() (Track, error) {
+	if err := e.Check(); err != nil {
+		return Track{}, err
+	}
+	return Track{
+		Title:    e.Title,
+		WebURL:   e.URL,
+		MediaURL: e.URL,
+		Duration: e.Duration,
+	}.Unresolved(), nil
+}
+
+func playlistKey(scope, ownerId, name string) string {
+	return scope + ":" + ownerId + ":" + strings.ToLower(name)
+}
+
+func (c *Client) LoadPlaylist(scope, ownerId, name string) (Playlist, bool, error) {
+	var playlist Playlist
+	found, err := c.Store.Get(STORE_BUCKET_PLAYLISTS, playlistKey(scope, ownerId, name), &playlist)
+	return playlist, found, err
+}
+
+func (c *Client) SavePlaylist(playlist Playlist) error {
+	playlist.UpdatedAt = time.Now()
+	return c.Store.Put(STORE_BUCKET_PLAYLISTS, playlistKey(playlist.Scope, playlist.OwnerID, playlist.Name), playlist)
+}
+
+func (c *Client) DeletePlaylist(playlist Playlist) error {
+	return c.Store.Delete(STORE_BUCKET_PLAYLISTS, playlistKey(playlist.Scope, playlist.OwnerID, playlist.Name))
+}
+
+func (pl Playlist) M3U() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("#EXTM3U\n")
+	for _, entry := range pl.Entries {
+		duration := entry.Duration
+		if duration == 0 {
+			duration = -1
+		}
+		buf.WriteString(fmt.Sprintf("#EXTINF:%d,%s\n%s\n", duration, entry.Title, entry.URL))
+	}
+	return buf.Bytes()
+}
+
+// Reads playlist entries out of either a JSON export or an M3U file
+func ParsePlaylistEntries(data []byte) ([]PlaylistEntry, error) {
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		var playlist Playlist
+		if err := json.Unmarshal(trimmed, &playlist); err != nil {
+			return nil, err
+		}
+		return playlist.Entries, checkPlaylistEntries(playlist.Entries)
+	}
+
+	entries := make([]PlaylistEntry, 0)
+	next := PlaylistEntry{}
+	scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line == "#EXTM3U":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			info := strings.TrimPrefix(line, "#EXTINF:")
+			if sep := strings.Index(info, ","); sep != -1 {
+				next.Title = strings.TrimSpace(info[sep+1:])
+				if duration, err := strconv.Atoi(strings.TrimSpace(info[:sep])); err == nil && duration > 0 {
+					next.Duration = duration
+				}
+			}
+		case strings.HasPrefix(line, "#"):
+		default:
+			next.URL = line
+			if next.Title == "" {
+				next.Title = line
+			}
+			entries = append(entries, next)
+			next = PlaylistEntry{}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, checkPlaylistEntries(entries)
+}
+
+func checkPlaylistEntries(entries []PlaylistEntry) error {
+	for _, entry := range entries {
+		if err := entry.Check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fetchPlaylistFile(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("playlist file request gave status code: %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, MAX_PLAYLIST_IMPORT_SIZE))
+}
+
+func (c *Client) PlaylistCommand(s *dgo.Session, i *dgo.InteractionCreate) {
+	if err := InteractionRespondDeferred(s, i); err != nil {
+		log.Printf(
+			"Failed sending deferred response into guild: %s, error: %s",
+			i.GuildID,
+			err,
+		)
+	}
+
+	if c.Store == nil {
+		InteractionErrorUpdate(s, i, PLAYLIST_NO_STORE_ERR)
+		return
+	}
+
+	subcommand, options := InteractionSubcommand(i)
+	name := strings.TrimSpace(options.String("name", ""))
+	if name == "" || len([]rune(name)) > MAX_PLAYLIST_NAME {
+		InteractionErrorUpdate(s, i, PLAYLIST_NAME_ERR)
+		return
+	}
+
+	scope, ownerId := PLAYLIST_SCOPE_USER, i.Member.User.ID
+	if options.String("scope", PLAYLIST_SCOPE_USER) == PLAYLIST_SCOPE_GUILD {
+		scope, ownerId = PLAYLIST_SCOPE_GUILD, i.GuildID
+	}
+
+	// Server playlists belong to everyone, only showing and playing them is free
+	if scope == PLAYLIST_SCOPE_GUILD && subcommand != "show" && subcommand != "play" && subcommand != "export" &&
+		!hasPermission(i.Member, dgo.PermissionManageServer) && !c.hasDJRole(s, i.GuildID, i.Member) {
+		InteractionErrorUpdate(s, i, PLAYLIST_GUILD_ERR)
+		return
+	}
+
+	playlist, found, err := c.LoadPlaylist(scope, ownerId, name)
+	if err != nil {
+		log.Printf("[STORE_ERR]: failed loading playlist %s: %v\n", name, err)
+		InteractionErrorUpdate(s, i, NO_PLAYER_AVAILABLE_ERR)
+		return
+	}
+
+	if subcommand == "create" {
+		if found {
+			InteractionErrorUpdate(s, i, PLAYLIST_EXISTS_ERR)
+			return
+		}
+		playlist = Playlist{Name: name, Scope: scope, OwnerID: ownerId, Entries: make([]PlaylistEntry, 0)}
+		c.savePlaylistAndReport(s, i, playlist, fmt.Sprintf("Created %s playlist %s", scope, name))
+		return
+	}
+
+	if !found && subcommand != "import" {
+		InteractionErrorUpdate(s, i, PLAYLIST_NOT_FOUND_ERR)
+		return
+	}
+
+	switch subcommand {
+	case "add":
+		c.playlistAdd(s, i, playlist, options)
+	case "remove":
+		position := options.Int("position", 0) - 1
+		if position < 0 || position >= len(playlist.Entries) {
+			InteractionErrorUpdate(s, i, PLAYLIST_POSITION_ERR)
+			return
+		}
+		removed := playlist.Entries[position]
+		playlist.Entries = append(playlist.Entries[:position], playlist.Entries[position+1:]...)
+		c.savePlaylistAndReport(s, i, playlist, fmt.Sprintf("Removed %s from %s", removed.Title, playlist.Name))
+	case "show":
+		c.playlistShow(s, i, playlist)
+	case "play":
+		c.playlistPlay(s, i, playlist)
+	case "delete":
+		if err := c.DeletePlaylist(playlist); err != nil {
+			log.Printf("[STORE_ERR]: failed deleting playlist %s: %v\n", name, err)
+			InteractionErrorUpdate(s, i, NO_PLAYER_AVAILABLE_ERR)
+			return
+		}
+		InteractionMessageUpdate(s, i, fmt.Sprintf("Deleted playlist %s", playlist.Name))
+	case "import":
+		if !found {
+			playlist = Playlist{Name: name, Scope: scope, OwnerID: ownerId}
+		}
+		c.playlistImport(s, i, playlist, options)
+	case "export":
+		c.playlistExport(s, i, playlist, options)
+	}
+}
+
+func (c *Client) savePlaylistAndReport(s *dgo.Session, i *dgo.InteractionCreate, playlist Playlist, msg string) {
+	if err := c.SavePlaylist(playlist); err != nil {
+		log.Printf("[STORE_ERR]: failed saving playlist %s: %v\n", playlist.Name, err)
+		InteractionErrorUpdate(s, i, NO_PLAYER_AVAILABLE_ERR)
+		return
+	}
+	InteractionMessageUpdate(s, i, msg)
+}
+
+func (c *Client) playlistAdd(s *dgo.Session, i *dgo.InteractionCreate, playlist Playlist, options CommandOptions) {
+	playback, ok := c.Playback(i.GuildID)
+	if !ok {
+		InteractionErrorUpdate(s, i, NO_PLAYER_AVAILABLE_ERR)
+		return
+	}
+
+	tracks := make([]Track, 0)
+	if options.String("source", "current") == "queue" {
+		tracks = playback.Queue.Tracks()
+	} else if playback.Title != "" {
+		tracks = append(tracks, playback.Track)
+	}
+
+	if len(tracks) == 0 {
+		InteractionErrorUpdate(s, i, NO_TRACK_PLAYING_ERR)
+		return
+	}
+
+	if len(playlist.Entries)+len(tracks) > MAX_PLAYLIST_ENTRIES {
+		InteractionErrorUpdate(s, i, PLAYLIST_FULL_ERR)
+		return
+	}
+
+	for _, track := range tracks {
+		playlist.Entries = append(playlist.Entries, NewPlaylistEntry(track))
+	}
+	c.savePlaylistAndReport(s, i, playlist, fmt.Sprintf("Added %d tracks to %s", len(tracks), playlist.Name))
+}
+
+func (c *Client) playlistShow(s *dgo.Session, i *dgo.InteractionCreate, playlist Playlist) {
+	var sb strings.Builder
+	total := 0
+	for idx, entry := range playlist.Entries {
+		total += entry.Duration
+		if idx < QUEUE_PAGE_SIZE*2 {
+			details := entry.Title
+			if track, err := entry.Track(); err == nil {
+				details = trackDetails(track)
+			}
+			sb.WriteString(fmt.Sprintf("`%d.` %s\n", idx+1, details))
+		}
+	}
+	if len(playlist.Entries) > QUEUE_PAGE_SIZE*2 {
+		sb.WriteString(fmt.Sprintf("... and %d more", len(playlist.Entries)-QUEUE_PAGE_SIZE*2))
+	}
+	if len(playlist.Entries) == 0 {
+		sb.WriteString(PLAYLIST_EMPTY_ERR)
+	}
+
+	embed := &dgo.MessageEmbed{
+		Title:       fmt.Sprintf("%s (%s playlist)", playlist.Name, playlist.Scope),
+		Description: sb.String(),
+		Color:       QUEUE_EMBED_COLOR,
+		Footer: &dgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("%d tracks • %s", len(playlist.Entries), FormatDuration(total)),
+		},
+	}
+	_, err := s.InteractionResponseEdit(i.Interaction, &dgo.WebhookEdit{
+		Embeds: &[]*dgo.MessageEmbed{embed},
+	})
+	if err != nil {
+		log.Printf("Failed sending playlist message to guild %s, error: %s", i.GuildID, err)
+	}
+}
+
+func (c *Client) playlistPlay(s *dgo.Session, i *dgo.InteractionCreate, playlist Playlist) {
+	if len(playlist.Entries) == 0 {
+		InteractionErrorUpdate(s, i, PLAYLIST_EMPTY_ERR)
+		return
+	}
+
+	playback, ok := c.Playback(i.GuildID)
+	if !ok {
+		InteractionErrorUpdate(s, i, NO_PLAYER_AVAILABLE_ERR)
+		return
+	}
+
+	voiceConnection, err := JoinUserVoiceChannel(s, i.GuildID, i.Member.User.ID)
+	if err != nil {
+		InteractionErrorUpdate(s, i, JOIN_CHANNEL_ERR)
+		return
+	}
+	playback.setVoiceConnection(voiceConnection)
+
+	tracks := make([]Track, 0, len(playlist.Entries))
+	for _, entry := range playlist.Entries {
+		track, err := entry.Track()
+		if err != nil {
+			InteractionErrorUpdate(s, i, PLAYLIST_ENTRY_URL_ERR)
+			return
+		}
+		track.Requester = i.Member.User.Username
+		track.RequesterID = i.Member.User.ID
+		tracks = append(tracks, track)
+	}
+	if err := playback.Queue.CheckSources(tracks...); err != nil {
+		InteractionErrorUpdate(s, i, err.Error())
+		return
+	}
+
+	// The whole playlist is either queued or rejected, playing starts once
+	// it's in the queue when nothing is being played.
+	if err := playback.Queue.Enqueue(tracks...); err != nil {
+		InteractionErrorUpdate(s, i, err.Error())
+		return
+	}
+	if playback.Player.State == enc.PlayerStatePlaying || playback.Player.State == enc.PlayerStatePaused {
+		InteractionMessageUpdate(s, i, fmt.Sprintf("Added %d tracks from %s to the queue", len(tracks), playlist.Name))
+		return
+	}
+
+	if track, ok := playback.Queue.Pop(); ok {
+		playback.Play(track)
+	}
+	InteractionMessageUpdate(s, i, fmt.Sprintf("Playing %d tracks from %s", len(tracks), playlist.Name))
+}
+
+func (c *Client) playlistImport(s *dgo.Session, i *dgo.InteractionCreate, playlist Playlist, options CommandOptions) {
+	attachment := options.Attachment(i, "file")
+	if attachment == nil || attachment.Size > MAX_PLAYLIST_IMPORT_SIZE {
+		InteractionErrorUpdate(s, i, PLAYLIST_IMPORT_ERR)
+		return
+	}
+
+	data, err := fetchPlaylistFile(attachment.URL)
+	if err != nil {
+		log.Printf("Failed downloading playlist file in guild %s, error: %s", i.GuildID, err)
+		InteractionErrorUpdate(s, i, PLAYLIST_IMPORT_ERR)
+		return
+	}
+
+	entries, err := ParsePlaylistEntries(data)
+	if errors.Is(err, ErrPlaylistEntryURL) {
+		InteractionErrorUpdate(s, i, PLAYLIST_ENTRY_URL_ERR)
+		return
+	}
+	if err != nil || len(entries) == 0 {
+		InteractionErrorUpdate(s, i, PLAYLIST_IMPORT_ERR)
+		return
+	}
+
+	if len(playlist.Entries)+len(entries) > MAX_PLAYLIST_ENTRIES {
+		InteractionErrorUpdate(s, i, PLAYLIST_FULL_ERR)
+		return
+	}
+
+	playlist.Entries = append(playlist.Entries, entries...)
+	c.savePlaylistAndReport(s, i, playlist, fmt.Sprintf("Imported %d tracks into %s", len(entries), playlist.Name))
+}
+
+func (c *Client) playlistExport(s *dgo.Session, i *dgo.InteractionCreate, playlist Playlist, options CommandOptions) {
+	format := options.String("format", "json")
+
+	var data []byte
+	if format == "m3u" {
+		data = playlist.M3U()
+	} else {
+		var err error
+		if data, err = json.MarshalIndent(playlist, "", "  "); err != nil {
+			log.Printf("Failed encoding playlist %s, error: %s", playlist.Name, err)
+			InteractionErrorUpdate(s, i, NO_PLAYER_AVAILABLE_ERR)
+			return
+		}
+	}
+
+	content := fmt.Sprintf("Playlist %s", playlist.Name)
+	_, err := s.InteractionResponseEdit(i.Interaction, &dgo.WebhookEdit{
+		Content: &content,
+		Files: []*dgo.File{
+			{
+				Name:        playlist.Name + "." + format,
+				ContentType: "text/plain",
+				Reader:      bytes.NewReader(data),
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Failed sending playlist export to guild %s, error: %s", i.GuildID, err)
+	}
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dgo "github.com/bwmarrin/discordgo"
+)
+
+const (
+	PODCAST_SELECT_ID         = "podcast_select"
+	PODCAST_MAX_EPISODES      = 25 // Discord select menus hold at most 25 options
+	PODCAST_MAX_FEED_BYTES    = 10 * 1024 * 1024
+	PODCAST_FETCH_TIMEOUT     = 15 * time.Second
+	PODCAST_FEED_ERR          = "Couldn't read any episode from the given feed"
+	PODCAST_EPISODE_GONE_ERR  = "This episode list expired, run the podcast command again"
+	PODCAST_NOTHING_TO_RESUME = "There's no podcast episode to resume"
+)
+
+type PodcastEpisode struct {
+	Title        string
+	Link         string
+	EnclosureURL string
+	Published    time.Time
+	Duration     int // Seconds, 0 if the feed doesn't tell
+}
+
+type PodcastFeed struct {
+	URL      string
+	Title    string
+	Episodes []PodcastEpisode
+}
+
+// Identifies who is listening to which episode, carried along the track
+type PodcastListen struct {
+	UserID       string
+	FeedTitle    string
+	EpisodeTitle string
+}
+
+type PodcastPosition struct {
+	PodcastListen
+	EnclosureURL string
+	Position     float32
+	UpdatedAt    time.Time
+}
+
+// Keeps fetched feeds around for the episode select menus, and the playback
+// position each user reached on every episode.
+type PodcastLibrary struct {
+	mu        sync.Mutex
+	feeds     map[string]PodcastFeed
+	positions map[string]map[string]PodcastPosition // user id -> enclosure url -> position
+}
+
+type rssFeed struct {
+	Channel struct {
+		Title string `xml:"title"`
+		Items []struct {
+			Title     string `xml:"title"`
+			Link      string `xml:"link"`
+			PubDate   string `xml:"pubDate"`
+			Duration  string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+			Enclosure struct {
+				URL  string `xml:"url,attr"`
+				Type string `xml:"type,attr"`
+			} `xml:"enclosure"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+type atomFeed struct {
+	Title   string `xml:"title"`
+	Entries []struct {
+		Title     string `xml:"title"`
+		Published string `xml:"published"`
+		Updated   string `xml:"updated"`
+		Duration  string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+		Links     []struct {
+			Rel  string `xml:"rel,attr"`
+			Href string `xml:"href,attr"`
+			Type string `xml:"type,attr"`
+		} `xml:"link"`
+	} `xml:"entry"`
+}
+
+func NewPodcastLibrary() *PodcastLibrary {
+	return &PodcastLibrary{
+		feeds:     make(map[string]PodcastFeed),
+		positions: make(map[string]map[string]PodcastPosition),
+	}
+}
+
+func FetchPodcastFeed(ctx context.Context, feedUrl string) (PodcastFeed, error) {
+	feed := PodcastFeed{URL: feedUrl}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", feedUrl, nil)
+	if err != nil {
+		return feed, fmt.Errorf("Failed creating feed request at: %s (got err: %v)", feedUrl, err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return feed, fmt.Errorf("Failed firing feed request at: %s (got err: %v)", feedUrl, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return feed, fmt.Errorf("Request at %s gave status code: %d", feedUrl, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, PODCAST_MAX_FEED_BYTES))
+	if err != nil {
+		return feed, fmt.Errorf("Failed reading feed at: %s (got err: %v)", feedUrl, err)
+	}
+
+	return ParsePodcastFeed(feedUrl, body)
+}
+
+// Parses either an RSS 2.0 or an Atom feed, keeping only the entries that
+// carry an audio/video enclosure. Episodes are sorted newest first.
+func ParsePodcastFeed(feedUrl string, body []byte) (PodcastFeed, error) {
+	feed := PodcastFeed{URL: feedUrl}
+
+	var root struct {
+		XMLName xml.Name
+	}
+	if err := xml.Unmarshal(body, &root); err != nil {
+		return feed, fmt.Errorf("Failed decoding feed at: %s (got err: %v)", feedUrl, err)
+	}
+
+	switch root.XMLName.Local {
+	case "rss":
+		var rss rssFeed
+		if err := xml.Unmarshal(body, &rss); err != nil {
+			return feed, fmt.Errorf("Failed decoding rss feed at: %s (got err: %v)", feedUrl, err)
+		}
+
+		feed.Title = strings.TrimSpace(rss.Channel.Title)
+		for _, item := range rss.Channel.Items {
+			if item.Enclosure.URL == "" {
+				continue
+			}
+			feed.Episodes = append(feed.Episodes, PodcastEpisode{
+				Title:        strings.TrimSpace(item.Title),
+				Link:         strings.TrimSpace(item.Link),
+				EnclosureURL: item.Enclosure.URL,
+				Published:    parseFeedTime(item.PubDate),
+				Duration:     parseFeedDuration(item.Duration),
+			})
+		}
+	case "feed":
+		var atom atomFeed
+		if err := xml.Unmarshal(body, &atom); err != nil {
+			return feed, fmt.Errorf("Failed decoding atom feed at: %s (got err: %v)", feedUrl, err)
+		}
+
+		feed.Title = strings.TrimSpace(atom.Title)
+		for _, entry := range atom.Entries {
+			episode := PodcastEpisode{
+				Title:     strings.TrimSpace(entry.Title),
+				Published: parseFeedTime(entry.Published),
+				Duration:  parseFeedDuration(entry.Duration),
+			}
+			if episode.Published.IsZero() {
+				episode.Published = parseFeedTime(entry.Updated)
+			}
+
+			for _, link := range entry.Links {
+				switch link.Rel {
+				case "enclosure":
+					episode.EnclosureURL = link.Href
+				case "", "alternate":
+					episode.Link = link.Href
+				}
+			}
+
+			if episode.EnclosureURL != "" {
+				feed.Episodes = append(feed.Episodes, episode)
+			}
+		}
+	default:
+		return feed, fmt.Errorf("Unsupported feed format at: %s (root element: %s)", feedUrl, root.XMLName.Local)
+	}
+
+	if len(feed.Episodes) == 0 {
+		return feed, fmt.Errorf("No episodes found in feed at: %s", feedUrl)
+	}
+
+	sort.SliceStable(feed.Episodes, func(i, j int) bool {
+		return feed.Episodes[i].Published.After(feed.Episodes[j].Published)
+	})
+
+	return feed, nil
+}
+
+func parseFeedTime(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{time.RFC1123Z, time.RFC1123, time.RFC3339, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Parses an itunes:duration, given either in seconds or as [hh:]mm:ss
+func parseFeedDuration(value string) int {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	duration := 0
+	for _, part := range strings.Split(value, ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0
+		}
+		duration = duration*60 + n
+	}
+	return duration
+}
+
+// Remembers feed and returns the short id select menus refer to it with
+func (pl *PodcastLibrary) AddFeed(feed PodcastFeed) string {
+	h := fnv.New32a()
+	h.Write([]byte(feed.URL))
+	feedId := strconv.FormatUint(uint64(h.Sum32()), 36)
+
+	pl.mu.Lock()
+	pl.feeds[feedId] = feed
+	pl.mu.Unlock()
+
+	return feedId
+}
+
+func (pl *PodcastLibrary) Episode(feedId string, index int) (PodcastFeed, PodcastEpisode, bool) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+
+	feed, ok := pl.feeds[feedId]
+	if !ok || index < 0 || index >= len(feed.Episodes) {
+		return feed, PodcastEpisode{}, false
+	}
+	return feed, feed.Episodes[index], true
+}
+
+// Records where the current podcast episode of playback is at, if any
+func (pl *PodcastLibrary) Snapshot(playback *Playback) {
+	if playback.Episode == nil {
+		return
+	}
+
+	position, ok := playback.Position()
+	if !ok {
+		return
+	}
+
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+
+	userId := playback.Episode.UserID
+	if _, ok := pl.positions[userId]; !ok {
+		pl.positions[userId] = make(map[string]PodcastPosition)
+	}
+	pl.positions[userId][playback.MediaURL] = PodcastPosition{
+		PodcastListen: *playback.Episode,
+		EnclosureURL:  playback.MediaURL,
+		Position:      position,
+		UpdatedAt:     time.Now(),
+	}
+}
+
+// The episode userId listened to most recently
+func (pl *PodcastLibrary) LastPosition(userId string) (PodcastPosition, bool) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+
+	var last PodcastPosition
+	found := false
+	for _, position := range pl.positions[userId] {
+		if !found || position.UpdatedAt.After(last.UpdatedAt) {
+			last = position
+			found = true
+		}
+	}
+	return last, found
+}
+
+// Periodically snapshots the position of every podcast episode being played
+func (c *Client) StartPodcastTracker(snapshotEvery int) chan struct{} {
+	stop := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(snapshotEvery) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			for _, playback := range c.Playbacks() {
+				c.Podcasts.Snapshot(playback)
+			}
+		}
+	}()
+
+	return stop
+}
+
+func (c *Client) PodcastCommand(s *dgo.Session, i *dgo.InteractionCreate) {
+	if err := InteractionRespondDeferred(s, i); err != nil {
+		log.Printf(
+			"Failed sending deferred response into guild: %s, error: %s",
+			i.GuildID,
+			err,
+		)
+	}
+
+	subcommand, options := InteractionSubcommand(i)
+	switch subcommand {
+	case "resume":
+		c.podcastResume(s, i)
+	case "feed":
+		c.podcastList(s, i, options.String("url", ""))
+	}
+}
+
+func (c *Client) podcastList(s *dgo.Session, i *dgo.InteractionCreate, feedUrl string) {
+	ctx, cancel := context.WithTimeout(context.Background(), PODCAST_FETCH_TIMEOUT)
+	defer cancel()
+
+	feed, err := FetchPodcastFeed(ctx, feedUrl)
+	if err != nil {
+		log.Println("[PODCAST_ERR]:", err)
+		if err := InteractionTextUpdate(s, i, PODCAST_FEED_ERR); err != nil {
+			log.Printf("Failed sending client error message to guild %s, client_error: %s, error: %s",
+				i.GuildID,
+				PODCAST_FEED_ERR,
+				err,
+			)
+		}
+		return
+	}
+
+	feedId := c.Podcasts.AddFeed(feed)
+	options := make([]dgo.SelectMenuOption, 0, PODCAST_MAX_EPISODES)
+	for idx, episode := range feed.Episodes {
+		if idx == PODCAST_MAX_EPISODES {
+			break
+		}
+
+		description := ""
+		if !episode.Published.IsZero() {
+			description = episode.Published.Format("2006-01-02")
+		}
+		options = append(options, dgo.SelectMenuOption{
+			Label:       truncate(episode.Title, 100),
+			Value:       fmt.Sprintf("%s:%d", feedId, idx),
+			Description: description,
+		})
+	}
+
+	content := fmt.Sprintf("Recent episodes of %s", feed.Title)
+	components := []dgo.MessageComponent{
+		dgo.ActionsRow{
+			Components: []dgo.MessageComponent{
+				dgo.SelectMenu{
+					MenuType:    dgo.StringSelectMenu,
+					CustomID:    PODCAST_SELECT_ID,
+					Placeholder: "Pick an episode",
+					Options:     options,
+				},
+			},
+		},
+	}
+
+	_, err = s.InteractionResponseEdit(i.Interaction, &dgo.WebhookEdit{
+		Content:    &content,
+		Components: &components,
+	})
+	if err != nil {
+		log.Printf("Failed sending client message to guild %s, client_message: %s, error: %s",
+			i.GuildID,
+			content,
+			err,
+		)
+	}
+}
+
+func (c *Client) podcastResume(s *dgo.Session, i *dgo.InteractionCreate) {
+	position, ok := c.Podcasts.LastPosition(i.Member.User.ID)
+	if !ok {
+		if err := InteractionTextUpdate(s, i, PODCAST_NOTHING_TO_RESUME); err != nil {
+			log.Printf("Failed sending client error message to guild %s, client_error: %s, error: %s",
+				i.GuildID,
+				PODCAST_NOTHING_TO_RESUME,
+				err,
+			)
+		}
+		return
+	}
+
+	listen := position.PodcastListen
+	c.playEpisode(s, i, Track{
+		Title:    fmt.Sprintf("%s - %s", listen.FeedTitle, listen.EpisodeTitle),
+		WebURL:   position.EnclosureURL,
+		MediaURL: position.EnclosureURL,
+		Seek:     position.Position,
+		Episode:  &listen,
+	})
+}
+
+func (c *Client) PodcastSelectComponent(s *dgo.Session, i *dgo.InteractionCreate) {
+	if err := InteractionRespondDeferred(s, i); err != nil {
+		log.Printf(
+			"Failed sending deferred response into guild: %s, error: %s",
+			i.GuildID,
+			err,
+		)
+	}
+
+	var feed PodcastFeed
+	var episode PodcastEpisode
+	ok := false
+
+	// Values are formatted as <feed id>:<episode index>
+	values := i.MessageComponentData().Values
+	if len(values) == 1 {
+		if sep := strings.LastIndex(values[0], ":"); sep != -1 {
+			if index, err := strconv.Atoi(values[0][sep+1:]); err == nil {
+				feed, episode, ok = c.Podcasts.Episode(values[0][:sep], index)
+			}
+		}
+	}
+
+	if !ok {
+		if err := InteractionTextUpdate(s, i, PODCAST_EPISODE_GONE_ERR); err != nil {
+			log.Printf("Failed sending client error message to guild %s, client_error: %s, error: %s",
+				i.GuildID,
+				PODCAST_EPISODE_GONE_ERR,
+				err,
+			)
+		}
+		return
+	}
+
+	webUrl := episode.Link
+	if webUrl == "" {
+		webUrl = episode.EnclosureURL
+	}
+
+	c.playEpisode(s, i, Track{
+		Title:    fmt.Sprintf("%s - %s", feed.Title, episode.Title),
+		WebURL:   webUrl,
+		MediaURL: episode.EnclosureURL,
+		Duration: episode.Duration,
+		Episode: &PodcastListen{
+			UserID:       i.Member.User.ID,
+			FeedTitle:    feed.Title,
+			EpisodeTitle: episode.Title,
+		},
+	})
+}
+
+func (c *Client) playEpisode(s *dgo.Session, i *dgo.InteractionCreate, track Track) {
+	voiceConnection, err := JoinUserVoiceChannel(s, i.GuildID, i.Member.User.ID)
+	if err != nil {
+		if err := InteractionTextUpdate(s, i, JOIN_CHANNEL_ERR); err != nil {
+			log.Printf("Failed sending client error message to guild %s, client_error: %s, error: %s",
+				i.GuildID,
+				JOIN_CHANNEL_ERR,
+				err,
+			)
+		}
+		return
+	}
+
+	c.playTrack(s, i, voiceConnection, track)
+}
+
+func truncate(s string, maxLen int) string {
+	runes := []rune(s)
+	if len(runes) <= maxLen {
+		return s
+	}
+	return string(runes[:maxLen-1]) + "…"
+}
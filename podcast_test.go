@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const rssFixture = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd">
+  <channel>
+    <title> Test Radio </title>
+    <item>
+      <title>Older episode</title>
+      <link>https://example.com/older</link>
+      <pubDate>Mon, 02 Jan 2006 15:04:05 -0700</pubDate>
+      <itunes:duration>1:02:03</itunes:duration>
+      <enclosure url="https://cdn.example.com/older.mp3" type="audio/mpeg" length="1"/>
+    </item>
+    <item>
+      <title>Show notes only</title>
+      <pubDate>Tue, 03 Jan 2006 15:04:05 -0700</pubDate>
+    </item>
+    <item>
+      <title>Newer episode</title>
+      <link>https://example.com/newer</link>
+      <pubDate>Wed, 04 Jan 2006 15:04:05 -0700</pubDate>
+      <itunes:duration>754</itunes:duration>
+      <enclosure url="https://cdn.example.com/newer.mp3" type="audio/mpeg" length="1"/>
+    </item>
+  </channel>
+</rss>`
+
+const atomFixture = `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd">
+  <title>Test Atom</title>
+  <entry>
+    <title>First</title>
+    <published>2006-01-02T15:04:05Z</published>
+    <itunes:duration>12:34</itunes:duration>
+    <link rel="alternate" href="https://example.com/first"/>
+    <link rel="enclosure" type="audio/ogg" href="https://cdn.example.com/first.ogg"/>
+  </entry>
+  <entry>
+    <title>Second</title>
+    <updated>2006-01-05T15:04:05Z</updated>
+    <link href="https://example.com/second"/>
+    <link rel="enclosure" type="audio/mpeg" href="https://cdn.example.com/second.mp3"/>
+  </entry>
+  <entry>
+    <title>No audio</title>
+    <published>2006-01-06T15:04:05Z</published>
+    <link href="https://example.com/no-audio"/>
+  </entry>
+</feed>`
+
+func serveFeeds(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rss", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(rssFixture))
+	})
+	mux.HandleFunc("/atom", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/atom+xml")
+		w.Write([]byte(atomFixture))
+	})
+	mux.HandleFunc("/html", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html><body>not a feed</body></html>"))
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func assertEpisodes(t *testing.T, feed PodcastFeed, want []PodcastEpisode) {
+	t.Helper()
+	if len(feed.Episodes) != len(want) {
+		t.Fatalf("got %d episodes, want %d: %+v", len(feed.Episodes), len(want), feed.Episodes)
+	}
+	for idx, episode := range feed.Episodes {
+		if episode.Title != want[idx].Title ||
+			episode.Link != want[idx].Link ||
+			episode.EnclosureURL != want[idx].EnclosureURL ||
+			episode.Duration != want[idx].Duration ||
+			!episode.Published.Equal(want[idx].Published) {
+			t.Errorf("episode %d is %+v, want %+v", idx, episode, want[idx])
+		}
+	}
+}
+
+func TestFetchPodcastFeedRSS(t *testing.T) {
+	server := serveFeeds(t)
+
+	feed, err := FetchPodcastFeed(context.Background(), server.URL+"/rss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.Title != "Test Radio" || feed.URL != server.URL+"/rss" {
+		t.Errorf("got feed %q at %s", feed.Title, feed.URL)
+	}
+
+	zone := time.FixedZone("", -7*60*60)
+	assertEpisodes(t, feed, []PodcastEpisode{
+		{
+			Title:        "Newer episode",
+			Link:         "https://example.com/newer",
+			EnclosureURL: "https://cdn.example.com/newer.mp3",
+			Published:    time.Date(2006, 1, 4, 15, 4, 5, 0, zone),
+			Duration:     754,
+		},
+		{
+			Title:        "Older episode",
+			Link:         "https://example.com/older",
+			EnclosureURL: "https://cdn.example.com/older.mp3",
+			Published:    time.Date(2006, 1, 2, 15, 4, 5, 0, zone),
+			Duration:     3723,
+		},
+	})
+}
+
+func TestFetchPodcastFeedAtom(t *testing.T) {
+	server := serveFeeds(t)
+
+	feed, err := FetchPodcastFeed(context.Background(), server.URL+"/atom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.Title != "Test Atom" {
+		t.Errorf("got feed %q", feed.Title)
+	}
+
+	assertEpisodes(t, feed, []PodcastEpisode{
+		{
+			Title:        "Second",
+			Link:         "https://example.com/second",
+			EnclosureURL: "https://cdn.example.com/second.mp3",
+			Published:    time.Date(2006, 1, 5, 15, 4, 5, 0, time.UTC),
+		},
+		{
+			Title:        "First",
+			Link:         "https://example.com/first",
+			EnclosureURL: "https://cdn.example.com/first.ogg",
+			Published:    time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+			Duration:     754,
+		},
+	})
+}
+
+func TestFetchPodcastFeedErrors(t *testing.T) {
+	server := serveFeeds(t)
+
+	for _, path := range []string{"/html", "/missing"} {
+		if _, err := FetchPodcastFeed(context.Background(), server.URL+path); err == nil {
+			t.Errorf("fetching %s didn't fail", path)
+		}
+	}
+}
+
+func TestParsePodcastFeedNoEpisodes(t *testing.T) {
+	body := []byte(`<rss version="2.0"><channel><title>Empty</title><item><title>Text</title></item></channel></rss>`)
+	if _, err := ParsePodcastFeed("https://example.com/feed", body); err == nil {
+		t.Error("parsing a feed without enclosures didn't fail")
+	}
+}
+
+func TestParseFeedDuration(t *testing.T) {
+	for value, want := range map[string]int{
+		"":         0,
+		"90":       90,
+		"01:30":    90,
+		"1:01:30":  3690,
+		" 2:05 ":   125,
+		"abc":      0,
+		"1:-5":     0,
+		"00:00:00": 0,
+	} {
+		if got := parseFeedDuration(value); got != want {
+			t.Errorf("parseFeedDuration(%q) = %d, want %d", value, got, want)
+		}
+	}
+}
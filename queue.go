@@ -0,0 +1,442 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type QueueEventKind int
+
+const (
+	QueueEventAdded QueueEventKind = iota
+	QueueEventPopped
+	QueueEventRemoved
+	QueueEventMoved
+	QueueEventCleared
+	QueueEventJumped
+	QueueEventShuffled
+	QueueEventDeduped
+)
+
+var ErrQueuePosition = errors.New("queue position out of bounds")
+
+// Whether new queues interleave tracks by requester, and how much a single
+// requester may have queued in them. Set from the command line flags.
+var (
+	FairQueue              bool
+	DefaultRequesterLimits RequesterLimits
+)
+
+// Caps on what a single requester can have queued at once, 0 disables a cap
+type RequesterLimits struct {
+	MaxTracks   int
+	MaxDuration int // Seconds, tracks of unknown duration don't count
+}
+
+// Reports a requester going over the queue limits
+type RequesterLimitError struct {
+	Queued   int
+	Limit    int
+	Duration bool
+}
+
+func (e *RequesterLimitError) Error() string {
+	if e.Duration {
+		return fmt.Sprintf(
+			"You can't queue more than %s of tracks, you already have %s queued",
+			FormatDuration(e.Limit),
+			FormatDuration(e.Queued),
+		)
+	}
+	return fmt.Sprintf("You can't queue more than %d tracks, you already have %d queued", e.Limit, e.Queued)
+}
+
+// Reports a queue that can't hold more tracks
+type QueueFullError struct {
+	Limit int
+}
+
+func (e *QueueFullError) Error() string {
+	return fmt.Sprintf("The queue is full, it can't hold more than %d tracks", e.Limit)
+}
+
+// Reports a track coming from a source the queue doesn't take
+type SourceNotAllowedError struct {
+	Source string
+}
+
+func (e *SourceNotAllowedError) Error() string {
+	return fmt.Sprintf("Tracks from %s sources aren't allowed in this server", e.Source)
+}
+
+// Describes a change to a queue, Tracks holds the queue content right after it
+type QueueEvent struct {
+	Kind   QueueEventKind
+	Tracks []Track
+}
+
+// The tracks waiting to be played. Safe for concurrent use, every operation
+// taking positions is 0 based and bounds checked.
+type Queue struct {
+	mu        sync.Mutex
+	tracks    []Track
+	listeners []func(QueueEvent)
+	rand      *rand.Rand
+	fair      bool
+	limits    RequesterLimits
+	maxLength int             // 0 for no limit
+	sources   map[string]bool // Track sources taken, empty takes any
+}
+
+func NewQueue() *Queue {
+	return &Queue{
+		tracks: make([]Track, 0),
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		fair:   FairQueue,
+		limits: DefaultRequesterLimits,
+	}
+}
+
+// Makes pushed tracks get interleaved round-robin by requester, instead of
+// appended. Tracks already queued keep their order.
+func (q *Queue) SetFair(fair bool) {
+	q.mu.Lock()
+	q.fair = fair
+	q.mu.Unlock()
+}
+
+func (q *Queue) SetRequesterLimits(limits RequesterLimits) {
+	q.mu.Lock()
+	q.limits = limits
+	q.mu.Unlock()
+}
+
+// Caps how many tracks the queue holds, 0 removes the cap
+func (q *Queue) SetMaxLength(maxLength int) {
+	q.mu.Lock()
+	q.maxLength = maxLength
+	q.mu.Unlock()
+}
+
+// Restricts the tracks added on behalf of requesters to sources, as reported
+// by Track.Source. No sources allows any.
+func (q *Queue) SetAllowedSources(sources []string) {
+	allowed := make(map[string]bool, len(sources))
+	for _, source := range sources {
+		allowed[source] = true
+	}
+
+	q.mu.Lock()
+	q.sources = allowed
+	q.mu.Unlock()
+}
+
+// Reports the first of tracks coming from a source that isn't allowed
+func (q *Queue) CheckSources(tracks ...Track) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.checkSources(tracks)
+}
+
+// Must be called with the lock held
+func (q *Queue) checkSources(tracks []Track) error {
+	if len(q.sources) == 0 {
+		return nil
+	}
+	for _, track := range tracks {
+		if source := track.Source(); !q.sources[source] {
+			return &SourceNotAllowedError{Source: source}
+		}
+	}
+	return nil
+}
+
+// Registers action to be called after every change to the queue
+func (q *Queue) Subscribe(action func(QueueEvent)) {
+	q.mu.Lock()
+	q.listeners = append(q.listeners, action)
+	q.mu.Unlock()
+}
+
+// Must be called with the lock held, listeners run once it's released
+func (q *Queue) changed(kind QueueEventKind) func() {
+	event := QueueEvent{Kind: kind, Tracks: q.snapshot()}
+	listeners := q.listeners
+	return func() {
+		for _, action := range listeners {
+			action(event)
+		}
+	}
+}
+
+func (q *Queue) snapshot() []Track {
+	tracks := make([]Track, len(q.tracks))
+	copy(tracks, q.tracks)
+	return tracks
+}
+
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.tracks)
+}
+
+// A copy of the queued tracks
+func (q *Queue) Tracks() []Track {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.snapshot()
+}
+
+func (q *Queue) Peek() (Track, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.tracks) == 0 {
+		return Track{}, false
+	}
+	return q.tracks[0], true
+}
+
+// Must be called with the lock held. In fair mode the n-th queued track of a
+// requester goes right after the n-th track of every other requester.
+func (q *Queue) add(track Track) {
+	if !q.fair {
+		q.tracks = append(q.tracks, track)
+		return
+	}
+
+	round := 0
+	for _, queued := range q.tracks {
+		if queued.RequesterID == track.RequesterID {
+			round++
+		}
+	}
+
+	pos := 0
+	rounds := make(map[string]int)
+	for idx, queued := range q.tracks {
+		if rounds[queued.RequesterID] <= round {
+			pos = idx + 1
+		}
+		rounds[queued.RequesterID]++
+	}
+
+	q.tracks = append(q.tracks[:pos:pos], append([]Track{track}, q.tracks[pos:]...)...)
+}
+
+// Adds tracks regardless of the requester limits
+func (q *Queue) Push(tracks ...Track) {
+	q.mu.Lock()
+	for _, track := range tracks {
+		q.add(track)
+	}
+	notify := q.changed(QueueEventAdded)
+	q.mu.Unlock()
+
+	notify()
+}
+
+// Adds tracks at the very end regardless of the requester limits, even when
+// the queue is fair
+func (q *Queue) Append(tracks ...Track) {
+	q.mu.Lock()
+	q.tracks = append(q.tracks, tracks...)
+	notify := q.changed(QueueEventAdded)
+	q.mu.Unlock()
+
+	notify()
+}
+
+// Adds tracks on behalf of their requesters, either all of them or none if
+// that would get any requester or the queue over the limits.
+func (q *Queue) Enqueue(tracks ...Track) error {
+	q.mu.Lock()
+	if err := q.checkLimits(tracks); err != nil {
+		q.mu.Unlock()
+		return err
+	}
+
+	for _, track := range tracks {
+		q.add(track)
+	}
+	notify := q.changed(QueueEventAdded)
+	q.mu.Unlock()
+
+	notify()
+	return nil
+}
+
+// Must be called with the lock held
+func (q *Queue) checkLimits(tracks []Track) error {
+	if q.maxLength > 0 && len(q.tracks)+len(tracks) > q.maxLength {
+		return &QueueFullError{Limit: q.maxLength}
+	}
+	if err := q.checkSources(tracks); err != nil {
+		return err
+	}
+
+	queuedCounts := make(map[string]int)
+	queuedDurations := make(map[string]int)
+	for _, queued := range q.tracks {
+		queuedCounts[queued.RequesterID]++
+		queuedDurations[queued.RequesterID] += queued.Duration
+	}
+
+	addedCounts := make(map[string]int)
+	addedDurations := make(map[string]int)
+	for _, track := range tracks {
+		id := track.RequesterID
+		if id == "" {
+			continue
+		}
+
+		addedCounts[id]++
+		addedDurations[id] += track.Duration
+		if q.limits.MaxTracks > 0 && queuedCounts[id]+addedCounts[id] > q.limits.MaxTracks {
+			return &RequesterLimitError{Queued: queuedCounts[id], Limit: q.limits.MaxTracks}
+		}
+		if q.limits.MaxDuration > 0 && queuedDurations[id]+addedDurations[id] > q.limits.MaxDuration {
+			return &RequesterLimitError{Queued: queuedDurations[id], Limit: q.limits.MaxDuration, Duration: true}
+		}
+	}
+	return nil
+}
+
+// Inserts track so that it ends up at pos, anything past the end appends it
+func (q *Queue) Insert(pos int, track Track) error {
+	q.mu.Lock()
+	if pos < 0 {
+		q.mu.Unlock()
+		return ErrQueuePosition
+	}
+	if pos > len(q.tracks) {
+		pos = len(q.tracks)
+	}
+
+	q.tracks = append(q.tracks[:pos:pos], append([]Track{track}, q.tracks[pos:]...)...)
+	notify := q.changed(QueueEventAdded)
+	q.mu.Unlock()
+
+	notify()
+	return nil
+}
+
+// Removes and returns the first track
+func (q *Queue) Pop() (Track, bool) {
+	q.mu.Lock()
+	if len(q.tracks) == 0 {
+		q.mu.Unlock()
+		return Track{}, false
+	}
+
+	track := q.tracks[0]
+	q.tracks = q.tracks[1:]
+	notify := q.changed(QueueEventPopped)
+	q.mu.Unlock()
+
+	notify()
+	return track, true
+}
+
+func (q *Queue) Remove(pos int) (Track, error) {
+	q.mu.Lock()
+	if pos < 0 || pos >= len(q.tracks) {
+		q.mu.Unlock()
+		return Track{}, ErrQueuePosition
+	}
+
+	track := q.tracks[pos]
+	q.tracks = append(q.tracks[:pos:pos], q.tracks[pos+1:]...)
+	notify := q.changed(QueueEventRemoved)
+	q.mu.Unlock()
+
+	notify()
+	return track, nil
+}
+
+// Moves the track at from so that it ends up at position to
+func (q *Queue) Move(from, to int) (Track, error) {
+	q.mu.Lock()
+	if from < 0 || from >= len(q.tracks) || to < 0 || to >= len(q.tracks) {
+		q.mu.Unlock()
+		return Track{}, ErrQueuePosition
+	}
+
+	track := q.tracks[from]
+	tracks := append(q.tracks[:from:from], q.tracks[from+1:]...)
+	tracks = append(tracks[:to], append([]Track{track}, tracks[to:]...)...)
+	q.tracks = tracks
+	notify := q.changed(QueueEventMoved)
+	q.mu.Unlock()
+
+	notify()
+	return track, nil
+}
+
+// Empties the queue, returning how many tracks got removed
+func (q *Queue) Clear() int {
+	q.mu.Lock()
+	removed := len(q.tracks)
+	q.tracks = make([]Track, 0)
+	notify := q.changed(QueueEventCleared)
+	q.mu.Unlock()
+
+	notify()
+	return removed
+}
+
+// Drops every track before pos, leaving the track at pos first in the queue
+func (q *Queue) Jump(pos int) (Track, error) {
+	q.mu.Lock()
+	if pos < 0 || pos >= len(q.tracks) {
+		q.mu.Unlock()
+		return Track{}, ErrQueuePosition
+	}
+
+	track := q.tracks[pos]
+	q.tracks = q.tracks[pos:]
+	notify := q.changed(QueueEventJumped)
+	q.mu.Unlock()
+
+	notify()
+	return track, nil
+}
+
+func (q *Queue) Shuffle() {
+	q.mu.Lock()
+	q.rand.Shuffle(len(q.tracks), func(i, j int) {
+		q.tracks[i], q.tracks[j] = q.tracks[j], q.tracks[i]
+	})
+	notify := q.changed(QueueEventShuffled)
+	q.mu.Unlock()
+
+	notify()
+}
+
+// Removes tracks pointing to the same source as an earlier one, returning
+// how many tracks got removed
+func (q *Queue) Dedupe() int {
+	q.mu.Lock()
+	seen := make(map[string]bool, len(q.tracks))
+	tracks := make([]Track, 0, len(q.tracks))
+	for _, track := range q.tracks {
+		key := track.SourceKey()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		tracks = append(tracks, track)
+	}
+
+	removed := len(q.tracks) - len(tracks)
+	q.tracks = tracks
+	notify := q.changed(QueueEventDeduped)
+	q.mu.Unlock()
+
+	notify()
+	return removed
+}
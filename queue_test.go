@@ -0,0 +1,337 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func titledTracks(titles ...string) []Track {
+	tracks := make([]Track, 0, len(titles))
+	for _, title := range titles {
+		tracks = append(tracks, Track{Title: title, WebURL: "https://example.com/" + title})
+	}
+	return tracks
+}
+
+func requestedTracks(requesterId string, titles ...string) []Track {
+	tracks := titledTracks(titles...)
+	for idx := range tracks {
+		tracks[idx].RequesterID = requesterId
+	}
+	return tracks
+}
+
+func queueTitles(q *Queue) []string {
+	titles := make([]string, 0, q.Len())
+	for _, track := range q.Tracks() {
+		titles = append(titles, track.Title)
+	}
+	return titles
+}
+
+func assertTitles(t *testing.T, q *Queue, want ...string) {
+	t.Helper()
+	if got := queueTitles(q); !reflect.DeepEqual(got, want) {
+		t.Fatalf("queue is %v, want %v", got, want)
+	}
+}
+
+func newTestQueue(titles ...string) *Queue {
+	q := NewQueue()
+	q.Push(titledTracks(titles...)...)
+	return q
+}
+
+func TestQueueBounds(t *testing.T) {
+	q := newTestQueue("a", "b", "c")
+
+	if _, err := q.Remove(-1); !errors.Is(err, ErrQueuePosition) {
+		t.Errorf("Remove(-1) = %v, want ErrQueuePosition", err)
+	}
+	if _, err := q.Remove(3); !errors.Is(err, ErrQueuePosition) {
+		t.Errorf("Remove(3) = %v, want ErrQueuePosition", err)
+	}
+	if _, err := q.Move(0, 3); !errors.Is(err, ErrQueuePosition) {
+		t.Errorf("Move(0, 3) = %v, want ErrQueuePosition", err)
+	}
+	if _, err := q.Move(-1, 0); !errors.Is(err, ErrQueuePosition) {
+		t.Errorf("Move(-1, 0) = %v, want ErrQueuePosition", err)
+	}
+	if _, err := q.Jump(3); !errors.Is(err, ErrQueuePosition) {
+		t.Errorf("Jump(3) = %v, want ErrQueuePosition", err)
+	}
+	if _, err := q.Jump(-1); !errors.Is(err, ErrQueuePosition) {
+		t.Errorf("Jump(-1) = %v, want ErrQueuePosition", err)
+	}
+	if err := q.Insert(-1, Track{Title: "x"}); !errors.Is(err, ErrQueuePosition) {
+		t.Errorf("Insert(-1) = %v, want ErrQueuePosition", err)
+	}
+
+	assertTitles(t, q, "a", "b", "c")
+}
+
+func TestQueueInsert(t *testing.T) {
+	q := newTestQueue("a", "b")
+
+	if err := q.Insert(1, Track{Title: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Insert(10, Track{Title: "y"}); err != nil {
+		t.Fatal(err)
+	}
+	assertTitles(t, q, "a", "x", "b", "y")
+}
+
+func TestQueueMove(t *testing.T) {
+	q := newTestQueue("a", "b", "c", "d")
+
+	moved, err := q.Move(0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.Title != "a" {
+		t.Errorf("moved %s, want a", moved.Title)
+	}
+	assertTitles(t, q, "b", "c", "a", "d")
+
+	if _, err := q.Move(3, 0); err != nil {
+		t.Fatal(err)
+	}
+	assertTitles(t, q, "d", "b", "c", "a")
+}
+
+func TestQueueJump(t *testing.T) {
+	q := newTestQueue("a", "b", "c", "d")
+
+	track, err := q.Jump(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if track.Title != "c" {
+		t.Errorf("jumped to %s, want c", track.Title)
+	}
+	assertTitles(t, q, "c", "d")
+}
+
+func TestQueueDedupe(t *testing.T) {
+	q := newTestQueue("a", "b", "a", "c", "b", "a")
+
+	if removed := q.Dedupe(); removed != 3 {
+		t.Errorf("Dedupe removed %d tracks, want 3", removed)
+	}
+	assertTitles(t, q, "a", "b", "c")
+
+	if removed := q.Dedupe(); removed != 0 {
+		t.Errorf("second Dedupe removed %d tracks, want 0", removed)
+	}
+}
+
+func TestQueueClear(t *testing.T) {
+	q := newTestQueue("a", "b", "c")
+
+	if removed := q.Clear(); removed != 3 {
+		t.Errorf("Clear removed %d tracks, want 3", removed)
+	}
+	if _, ok := q.Peek(); ok || q.Len() != 0 {
+		t.Errorf("queue holds %d tracks after Clear", q.Len())
+	}
+	if removed := q.Clear(); removed != 0 {
+		t.Errorf("second Clear removed %d tracks, want 0", removed)
+	}
+}
+
+func TestQueueShuffle(t *testing.T) {
+	titles := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	q := newTestQueue(titles...)
+
+	q.Shuffle()
+	got := queueTitles(q)
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, titles) {
+		t.Fatalf("shuffled queue holds %v, want the same tracks as %v", got, titles)
+	}
+}
+
+func TestQueuePop(t *testing.T) {
+	q := newTestQueue("a", "b")
+
+	for _, want := range []string{"a", "b"} {
+		track, ok := q.Pop()
+		if !ok || track.Title != want {
+			t.Fatalf("Pop = %s, %v, want %s", track.Title, ok, want)
+		}
+	}
+	if _, ok := q.Pop(); ok {
+		t.Error("popped from an empty queue")
+	}
+}
+
+func TestQueueSubscribe(t *testing.T) {
+	q := newTestQueue("a", "b", "c")
+
+	events := make([]QueueEvent, 0)
+	lengths := make([]int, 0)
+	q.Subscribe(func(event QueueEvent) {
+		events = append(events, event)
+		// Would deadlock if listeners ran with the lock held
+		lengths = append(lengths, q.Len())
+	})
+
+	q.Push(titledTracks("d")...)
+	q.Pop()
+	q.Remove(0)
+	q.Move(0, 1)
+	q.Jump(1)
+	q.Shuffle()
+	q.Dedupe()
+	q.Clear()
+
+	kinds := []QueueEventKind{
+		QueueEventAdded,
+		QueueEventPopped,
+		QueueEventRemoved,
+		QueueEventMoved,
+		QueueEventJumped,
+		QueueEventShuffled,
+		QueueEventDeduped,
+		QueueEventCleared,
+	}
+	if len(events) != len(kinds) {
+		t.Fatalf("got %d events, want %d", len(events), len(kinds))
+	}
+	for idx, kind := range kinds {
+		if events[idx].Kind != kind {
+			t.Errorf("event %d is %v, want %v", idx, events[idx].Kind, kind)
+		}
+		if len(events[idx].Tracks) != lengths[idx] {
+			t.Errorf("event %d holds %d tracks, the queue %d", idx, len(events[idx].Tracks), lengths[idx])
+		}
+	}
+
+	// Snapshots belong to the listeners, changing them leaves the queue alone
+	q.Push(titledTracks("x", "y")...)
+	last := events[len(events)-1]
+	if !reflect.DeepEqual([]string{last.Tracks[0].Title, last.Tracks[1].Title}, []string{"x", "y"}) {
+		t.Fatalf("snapshot is %v, want x and y", last.Tracks)
+	}
+	last.Tracks[0].Title = "changed"
+	assertTitles(t, q, "x", "y")
+}
+
+func TestQueueFairInterleaving(t *testing.T) {
+	q := newTestQueue()
+	q.SetFair(true)
+
+	q.Push(requestedTracks("alice", "a1", "a2", "a3")...)
+	q.Push(requestedTracks("bob", "b1", "b2")...)
+	q.Push(requestedTracks("carol", "c1")...)
+	assertTitles(t, q, "a1", "b1", "c1", "a2", "b2", "a3")
+
+	q.Push(requestedTracks("carol", "c2")...)
+	assertTitles(t, q, "a1", "b1", "c1", "a2", "b2", "c2", "a3")
+
+	// Looped tracks go back at the very end
+	q.Append(requestedTracks("alice", "a0")...)
+	assertTitles(t, q, "a1", "b1", "c1", "a2", "b2", "c2", "a3", "a0")
+}
+
+func TestQueueEnqueueLimits(t *testing.T) {
+	q := newTestQueue()
+	q.SetRequesterLimits(RequesterLimits{MaxTracks: 2})
+
+	if err := q.Enqueue(requestedTracks("alice", "a1")...); err != nil {
+		t.Fatal(err)
+	}
+
+	// Going over the limit rejects every track, not only the last ones
+	err := q.Enqueue(append(requestedTracks("bob", "b1"), requestedTracks("alice", "a2", "a3")...)...)
+	var limitErr *RequesterLimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("Enqueue = %v, want a RequesterLimitError", err)
+	}
+	if limitErr.Queued != 1 || limitErr.Limit != 2 || limitErr.Duration {
+		t.Errorf("got %+v, want 1 queued out of 2 tracks", limitErr)
+	}
+	assertTitles(t, q, "a1")
+
+	if err := q.Enqueue(requestedTracks("alice", "a2")...); err != nil {
+		t.Fatal(err)
+	}
+	assertTitles(t, q, "a1", "a2")
+
+	// Tracks without requester aren't limited, pushed ones neither
+	if err := q.Enqueue(titledTracks("x")...); err != nil {
+		t.Fatal(err)
+	}
+	q.Push(requestedTracks("alice", "a3")...)
+	assertTitles(t, q, "a1", "a2", "x", "a3")
+}
+
+func TestQueueEnqueueDurationLimit(t *testing.T) {
+	q := newTestQueue()
+	q.SetRequesterLimits(RequesterLimits{MaxDuration: 600})
+
+	tracks := requestedTracks("alice", "a1", "a2")
+	tracks[0].Duration = 400
+	tracks[1].Duration = 300
+
+	var limitErr *RequesterLimitError
+	if err := q.Enqueue(tracks...); !errors.As(err, &limitErr) || !limitErr.Duration {
+		t.Fatalf("Enqueue = %v, want a duration RequesterLimitError", err)
+	}
+	if q.Len() != 0 {
+		t.Errorf("queue holds %d tracks, want none", q.Len())
+	}
+}
+
+func TestQueueMaxLength(t *testing.T) {
+	q := newTestQueue("a")
+	q.SetMaxLength(2)
+
+	var fullErr *QueueFullError
+	if err := q.Enqueue(titledTracks("b", "c")...); !errors.As(err, &fullErr) || fullErr.Limit != 2 {
+		t.Fatalf("Enqueue = %v, want a QueueFullError", err)
+	}
+	assertTitles(t, q, "a")
+
+	if err := q.Enqueue(titledTracks("b")...); err != nil {
+		t.Fatal(err)
+	}
+
+	q.SetMaxLength(0)
+	if err := q.Enqueue(titledTracks("c")...); err != nil {
+		t.Fatal(err)
+	}
+	assertTitles(t, q, "a", "b", "c")
+}
+
+func TestQueueAllowedSources(t *testing.T) {
+	q := newTestQueue()
+	q.SetAllowedSources([]string{TRACK_SOURCE_YOUTUBE})
+
+	youtube := Track{Title: "yt", WebURL: "https://www.youtube.com/watch?v=dQw4w9WgXcQ"}
+	stream := Track{Title: "stream", WebURL: "Unknown source", MediaURL: "https://example.com/stream.mp3"}
+
+	var sourceErr *SourceNotAllowedError
+	if err := q.Enqueue(youtube, stream); !errors.As(err, &sourceErr) || sourceErr.Source != TRACK_SOURCE_URL {
+		t.Fatalf("Enqueue = %v, want a SourceNotAllowedError for %s", err, TRACK_SOURCE_URL)
+	}
+	if q.Len() != 0 {
+		t.Errorf("queue holds %d tracks, want none", q.Len())
+	}
+
+	if err := q.Enqueue(youtube); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.CheckSources(stream); err == nil {
+		t.Error("CheckSources let a url through")
+	}
+
+	q.SetAllowedSources(nil)
+	if err := q.Enqueue(stream); err != nil {
+		t.Fatal(err)
+	}
+	assertTitles(t, q, "yt", "stream")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+
+	dgo "github.com/bwmarrin/discordgo"
+)
+
+const (
+	QUEUE_POSITION_ERR = "There's no track at that position in the queue"
+)
+
+func positionOptions(i *dgo.InteractionCreate) map[string]int {
+	options := InteractionOptions(i)
+	positions := make(map[string]int, len(options))
+	for name := range options {
+		// Positions shown to users start from 1
+		positions[name] = options.Int(name, 0) - 1
+	}
+	return positions
+}
+
+func (c *Client) RemoveCommand(s *dgo.Session, i *dgo.InteractionCreate) {
+	playback, ok := c.deferredPlayback(s, i)
+	if !ok {
+		return
+	}
+
+	track, err := playback.Queue.Remove(positionOptions(i)["position"])
+	if err != nil {
+		InteractionErrorUpdate(s, i, QUEUE_POSITION_ERR)
+		return
+	}
+
+	InteractionMessageUpdate(s, i, fmt.Sprintf("Removed %s | %s from the queue", track.Title, track.WebURL))
+}
+
+func (c *Client) MoveCommand(s *dgo.Session, i *dgo.InteractionCreate) {
+	playback, ok := c.deferredPlayback(s, i)
+	if !ok {
+		return
+	}
+
+	positions := positionOptions(i)
+	track, err := playback.Queue.Move(positions["from"], positions["to"])
+	if err != nil {
+		InteractionErrorUpdate(s, i, QUEUE_POSITION_ERR)
+		return
+	}
+
+	InteractionMessageUpdate(s, i, fmt.Sprintf("Moved %s to position %d", track.Title, positions["to"]+1))
+}
+
+func (c *Client) ClearCommand(s *dgo.Session, i *dgo.InteractionCreate) {
+	playback, ok := c.deferredPlayback(s, i)
+	if !ok {
+		return
+	}
+
+	removed := playback.Queue.Clear()
+	InteractionMessageUpdate(s, i, fmt.Sprintf("Removed %d tracks from the queue", removed))
+}
+
+func (c *Client) JumpCommand(s *dgo.Session, i *dgo.InteractionCreate) {
+	playback, ok := c.deferredPlayback(s, i)
+	if !ok {
+		return
+	}
+
+	if playback.VoiceConnection() == nil {
+		InteractionErrorUpdate(s, i, NO_VOICE_CONNECTION_ERR)
+		return
+	}
+
+	if _, err := playback.Queue.Jump(positionOptions(i)["position"]); err != nil {
+		InteractionErrorUpdate(s, i, QUEUE_POSITION_ERR)
+		return
+	}
+
+	c.Podcasts.Snapshot(playback)
+	track, ok := playback.Skip()
+	if !ok {
+		InteractionErrorUpdate(s, i, QUEUE_EMPTY_ERR)
+		return
+	}
+
+	InteractionMessageUpdate(s, i, playback.NowPlayingMessage(track))
+}
+
+func (c *Client) ShuffleCommand(s *dgo.Session, i *dgo.InteractionCreate) {
+	playback, ok := c.deferredPlayback(s, i)
+	if !ok {
+		return
+	}
+
+	playback.Queue.Shuffle()
+	InteractionMessageUpdate(s, i, fmt.Sprintf("Shuffled %d tracks", playback.Queue.Len()))
+}
+
+func (c *Client) DedupeCommand(s *dgo.Session, i *dgo.InteractionCreate) {
+	playback, ok := c.deferredPlayback(s, i)
+	if !ok {
+		return
+	}
+
+	removed := playback.Queue.Dedupe()
+	InteractionMessageUpdate(s, i, fmt.Sprintf("Removed %d duplicate tracks from the queue", removed))
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"ndmb/enc"
+
+	dgo "github.com/bwmarrin/discordgo"
+)
+
+const (
+	QUEUE_PAGE_ID     = "queue_page:"
+	QUEUE_PAGE_SIZE   = 10
+	QUEUE_EMBED_COLOR = 0x1db954
+)
+
+// The last queue listing sent in a guild, kept up to date as the queue changes
+type QueueView struct {
+	ChannelID string
+	MessageID string
+	Page      int
+}
+
+func trackLink(track Track) string {
+	if strings.HasPrefix(track.WebURL, "http") {
+		return fmt.Sprintf("[%s](%s)", track.Title, track.WebURL)
+	}
+	return track.Title
+}
+
+func trackDetails(track Track) string {
+	details := trackLink(track)
+	if track.Autoplayed {
+		details += " • autoplay"
+	} else if track.Requester != "" {
+		details += " • " + track.Requester
+	}
+	if track.Duration > 0 {
+		details += " • " + FormatDuration(track.Duration)
+	}
+	return details
+}
+
+// Builds the queue embed for page, clamping page to the available ones
+func (p *Playback) QueueEmbed(page int) (*dgo.MessageEmbed, []dgo.MessageComponent, int) {
+	queue := p.Queue.Tracks()
+	pages := (len(queue) + QUEUE_PAGE_SIZE - 1) / QUEUE_PAGE_SIZE
+	if pages == 0 {
+		pages = 1
+	}
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+
+	var sb strings.Builder
+	remaining := 0
+	unknownDurations := 0
+
+	if p.Player.State == enc.PlayerStatePlaying || p.Player.State == enc.PlayerStatePaused {
+		sb.WriteString("**Now playing:** " + trackDetails(p.Track) + "\n\n")
+		if p.Duration > 0 {
+			position, _ := p.Position()
+			remaining += p.Duration - int(position)
+		} else {
+			unknownDurations++
+		}
+	}
+
+	if len(queue) == 0 {
+		sb.WriteString("The queue is empty")
+	}
+
+	for idx, track := range queue {
+		if idx >= page*QUEUE_PAGE_SIZE && idx < (page+1)*QUEUE_PAGE_SIZE {
+			sb.WriteString(fmt.Sprintf("`%d.` %s\n", idx+1, trackDetails(track)))
+		}
+
+		if track.Duration > 0 {
+			remaining += track.Duration
+		} else {
+			unknownDurations++
+		}
+	}
+
+	footer := fmt.Sprintf("Page %d/%d • %d tracks • %s remaining", page+1, pages, len(queue), FormatDuration(remaining))
+	if unknownDurations > 0 {
+		footer += fmt.Sprintf(" (+%d of unknown length)", unknownDurations)
+	}
+	footer += fmt.Sprintf(" • loop %s", p.Loop)
+	if p.Autoplay {
+		footer += " • autoplay on"
+	}
+
+	embed := &dgo.MessageEmbed{
+		Title:       "Queue",
+		Description: sb.String(),
+		Color:       QUEUE_EMBED_COLOR,
+		Footer:      &dgo.MessageEmbedFooter{Text: footer},
+	}
+
+	components := []dgo.MessageComponent{
+		dgo.ActionsRow{
+			Components: []dgo.MessageComponent{
+				dgo.Button{
+					Label:    "Previous",
+					Style:    dgo.SecondaryButton,
+					CustomID: QUEUE_PAGE_ID + strconv.Itoa(page-1),
+					Disabled: page == 0,
+				},
+				dgo.Button{
+					Label:    "Next",
+					Style:    dgo.SecondaryButton,
+					CustomID: QUEUE_PAGE_ID + strconv.Itoa(page+1),
+					Disabled: page >= pages-1,
+				},
+			},
+		},
+	}
+
+	return embed, components, page
+}
+
+// Edits the last queue listing of p in place, if there's any
+func (c *Client) RefreshQueueView(s *dgo.Session, p *Playback) {
+	p.queueViewLock.Lock()
+	defer p.queueViewLock.Unlock()
+
+	view := p.queueView
+	if view == nil {
+		return
+	}
+
+	embed, components, page := p.QueueEmbed(view.Page)
+	view.Page = page
+
+	_, err := s.ChannelMessageEditComplex(&dgo.MessageEdit{
+		ID:         view.MessageID,
+		Channel:    view.ChannelID,
+		Embeds:     []*dgo.MessageEmbed{embed},
+		Components: components,
+	})
+	if err != nil {
+		log.Printf("Failed refreshing queue message %s in channel %s, error: %s", view.MessageID, view.ChannelID, err)
+		// The message is probably gone, stop tracking it
+		p.queueView = nil
+	}
+}
+
+func (c *Client) QueueCommand(s *dgo.Session, i *dgo.InteractionCreate) {
+	if err := InteractionRespondDeferred(s, i); err != nil {
+		log.Printf(
+			"Failed sending deferred response into guild: %s, error: %s",
+			i.GuildID,
+			err,
+		)
+	}
+
+	playback, ok := c.Playback(i.GuildID)
+	if !ok {
+		err := InteractionTextUpdate(s, i, NO_PLAYER_AVAILABLE_ERR)
+		if err != nil {
+			log.Printf("Failed sending client error message to guild %s, client_error: %s, error: %s",
+				i.GuildID,
+				NO_PLAYER_AVAILABLE_ERR,
+				err,
+			)
+		}
+		return
+	}
+
+	embed, components, page := playback.QueueEmbed(0)
+	msg, err := s.InteractionResponseEdit(i.Interaction, &dgo.WebhookEdit{
+		Embeds:     &[]*dgo.MessageEmbed{embed},
+		Components: &components,
+	})
+	if err != nil {
+		log.Printf("Failed sending queue message to guild %s, error: %s", i.GuildID, err)
+		return
+	}
+
+	playback.queueViewLock.Lock()
+	playback.queueView = &QueueView{
+		ChannelID: msg.ChannelID,
+		MessageID: msg.ID,
+		Page:      page,
+	}
+	playback.queueViewLock.Unlock()
+}
+
+func (c *Client) QueuePageComponent(s *dgo.Session, i *dgo.InteractionCreate) {
+	playback, ok := c.Playback(i.GuildID)
+	if !ok {
+		ReportGenericError(NO_PLAYER_AVAILABLE_ERR, s, i)
+		return
+	}
+
+	page, err := strconv.Atoi(strings.TrimPrefix(i.MessageComponentData().CustomID, QUEUE_PAGE_ID))
+	if err != nil {
+		page = 0
+	}
+
+	embed, components, page := playback.QueueEmbed(page)
+	err = s.InteractionRespond(i.Interaction, &dgo.InteractionResponse{
+		Type: dgo.InteractionResponseUpdateMessage,
+		Data: &dgo.InteractionResponseData{
+			Embeds:     []*dgo.MessageEmbed{embed},
+			Components: components,
+		},
+	})
+	if err != nil {
+		log.Printf("Failed updating queue message in guild %s, error: %s", i.GuildID, err)
+		return
+	}
+
+	playback.queueViewLock.Lock()
+	playback.queueView = &QueueView{
+		ChannelID: i.ChannelID,
+		MessageID: i.Message.ID,
+		Page:      page,
+	}
+	playback.queueViewLock.Unlock()
+}
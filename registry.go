@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	dgo "github.com/bwmarrin/discordgo"
+)
+
+// Everything about a command: its schema, what handles it and who can use it
+type CommandSpec struct {
+	Command      *dgo.ApplicationCommand
+	Handler      InteractionHandler
+	Autocomplete InteractionHandler // Nil if none of its options autocompletes
+	Restrictable bool               // Whether /config permissions can restrict it
+	Cooldown     time.Duration      // Minimum time between uses of a single user
+}
+
+// The single source commands are registered and dispatched from
+type CommandRegistry struct {
+	specs []CommandSpec
+
+	mu       sync.Mutex
+	lastUses map[string]time.Time // By user id and command name
+}
+
+func NewCommandRegistry() *CommandRegistry {
+	return &CommandRegistry{
+		specs:    make([]CommandSpec, 0),
+		lastUses: make(map[string]time.Time),
+	}
+}
+
+func (r *CommandRegistry) Add(specs ...CommandSpec) {
+	r.specs = append(r.specs, specs...)
+}
+
+// Reports commands without a name or handler, and names used more than once
+func (r *CommandRegistry) Validate() error {
+	names := make(map[string]bool, len(r.specs))
+	for idx, spec := range r.specs {
+		if spec.Command == nil || spec.Command.Name == "" {
+			return fmt.Errorf("command number %d has no name", idx)
+		}
+		name := spec.Command.Name
+		if spec.Handler == nil {
+			return fmt.Errorf("command %s has no handler", name)
+		}
+		if names[name] {
+			return fmt.Errorf("command %s is declared more than once", name)
+		}
+		names[name] = true
+	}
+	return nil
+}
+
+func (r *CommandRegistry) ApplicationCommands() []*dgo.ApplicationCommand {
+	commands := make([]*dgo.ApplicationCommand, 0, len(r.specs))
+	for _, spec := range r.specs {
+		commands = append(commands, spec.Command)
+	}
+	return commands
+}
+
+// Names of the commands that can be restricted
+func (r *CommandRegistry) Restrictable() []string {
+	names := make([]string, 0)
+	for _, spec := range r.specs {
+		if spec.Restrictable {
+			names = append(names, spec.Command.Name)
+		}
+	}
+	return names
+}
+
+// Whether the user can use spec right now, recording the use if so. Returns
+// how long the user has to wait otherwise.
+func (r *CommandRegistry) use(spec CommandSpec, userId string) (time.Duration, bool) {
+	if spec.Cooldown <= 0 {
+		return 0, true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := userId + ":" + spec.Command.Name
+	if wait := spec.Cooldown - time.Since(r.lastUses[key]); wait > 0 {
+		return wait, false
+	}
+	r.lastUses[key] = time.Now()
+	return 0, true
+}
+
+// Registers every command and autocompletion handler into router, enforcing
+// the command cooldowns.
+func (r *CommandRegistry) Route(router *Router) {
+	specs := make(map[string]CommandSpec, len(r.specs))
+	for _, spec := range r.specs {
+		specs[spec.Command.Name] = spec
+		router.Command(spec.Command.Name, spec.Handler)
+		if spec.Autocomplete != nil {
+			router.Autocomplete(spec.Command.Name, spec.Autocomplete)
+		}
+	}
+
+	router.Use(func(s *dgo.Session, i *dgo.InteractionCreate, route string) bool {
+		spec, ok := specs[route]
+		if i.Type != dgo.InteractionApplicationCommand || !ok {
+			return true
+		}
+
+		wait, ok := r.use(spec, i.Member.User.ID)
+		if !ok {
+			log.Printf("User %s is on cooldown for command %s\n", i.Member.User.Username, route)
+			InteractionEphemeralRespond(s, i, fmt.Sprintf("Slow down, you can use /%s again in %.0f seconds", route, wait.Seconds()+0.5))
+		}
+		return ok
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	dgo "github.com/bwmarrin/discordgo"
+)
+
+func noopHandler(s *dgo.Session, i *dgo.InteractionCreate) {}
+
+func TestCommandRegistryValidatesRealCommands(t *testing.T) {
+	r := (&Client{}).CommandRegistry()
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.ApplicationCommands()) == 0 {
+		t.Fatal("no commands registered")
+	}
+
+	for _, spec := range r.specs {
+		if spec.Command.Description == "" {
+			t.Errorf("command %s has no description", spec.Command.Name)
+		}
+	}
+}
+
+func TestCommandRegistryValidateFailures(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		specs []CommandSpec
+		want  string
+	}{
+		{
+			name: "duplicate name",
+			specs: []CommandSpec{
+				{Command: &dgo.ApplicationCommand{Name: "play"}, Handler: noopHandler},
+				{Command: &dgo.ApplicationCommand{Name: "play"}, Handler: noopHandler},
+			},
+			want: "more than once",
+		},
+		{
+			name: "nil handler",
+			specs: []CommandSpec{
+				{Command: &dgo.ApplicationCommand{Name: "play"}},
+			},
+			want: "no handler",
+		},
+		{
+			name: "nil command",
+			specs: []CommandSpec{
+				{Handler: noopHandler},
+			},
+			want: "no name",
+		},
+		{
+			name: "empty name",
+			specs: []CommandSpec{
+				{Command: &dgo.ApplicationCommand{}, Handler: noopHandler},
+			},
+			want: "no name",
+		},
+	} {
+		r := NewCommandRegistry()
+		r.Add(tc.specs...)
+		err := r.Validate()
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: Validate() = %v, want an error about %q", tc.name, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Bounds the amount of concurrent audio source resolutions (each one of them
+// possibly spawning a yt-dlp process) and coalesces identical lookups that
+// are in flight at the same time.
+type Resolver struct {
+	slots    chan struct{}
+	timeout  time.Duration
+	mu       sync.Mutex
+	inflight map[string]*resolveCall
+
+	waiting   int32
+	running   int32
+	coalesced int64
+}
+
+type resolveCall struct {
+	done  chan struct{}
+	track Track
+	err   error
+}
+
+type ResolverStats struct {
+	Workers   int
+	Running   int
+	Waiting   int
+	Coalesced int64
+}
+
+var DefaultResolver = NewResolver(4, 30*time.Second)
+
+func SetResolverLimits(maxWorkers int, timeout time.Duration) {
+	DefaultResolver = NewResolver(maxWorkers, timeout)
+}
+
+func NewResolver(maxWorkers int, timeout time.Duration) *Resolver {
+	if maxWorkers < 1 {
+		maxWorkers = 1
+	}
+
+	return &Resolver{
+		slots:    make(chan struct{}, maxWorkers),
+		timeout:  timeout,
+		inflight: make(map[string]*resolveCall),
+	}
+}
+
+// Resolves input into a playable track. Callers asking for the same input
+// while a resolution is still running share its result.
+func (r *Resolver) Resolve(input string) (Track, error) {
+	key := strings.TrimSpace(input)
+
+	r.mu.Lock()
+	if call, ok := r.inflight[key]; ok {
+		r.mu.Unlock()
+		atomic.AddInt64(&r.coalesced, 1)
+		<-call.done
+		return call.track, call.err
+	}
+
+	call := &resolveCall{done: make(chan struct{})}
+	r.inflight[key] = call
+	r.mu.Unlock()
+
+	// Waiters get released even if the resolution panics
+	defer func() {
+		r.mu.Lock()
+		delete(r.inflight, key)
+		r.mu.Unlock()
+		close(call.done)
+	}()
+
+	atomic.AddInt32(&r.waiting, 1)
+	r.slots <- struct{}{}
+	atomic.AddInt32(&r.waiting, -1)
+	atomic.AddInt32(&r.running, 1)
+	defer func() {
+		atomic.AddInt32(&r.running, -1)
+		<-r.slots
+	}()
+
+	ctx := context.Background()
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	call.track, call.err = ResolveAudioSource(ctx, key)
+	if call.err != nil && ctx.Err() == context.DeadlineExceeded {
+		call.err = fmt.Errorf("resolving %s timed out after %s", key, r.timeout)
+	}
+
+	return call.track, call.err
+}
+
+func (r *Resolver) Stats() ResolverStats {
+	return ResolverStats{
+		Workers:   cap(r.slots),
+		Running:   int(atomic.LoadInt32(&r.running)),
+		Waiting:   int(atomic.LoadInt32(&r.waiting)),
+		Coalesced: atomic.LoadInt64(&r.coalesced),
+	}
+}
+
+func (rs ResolverStats) String() string {
+	return fmt.Sprintf("resolver running %d/%d, waiting %d, coalesced %d",
+		rs.Running, rs.Workers, rs.Waiting, rs.Coalesced)
+}
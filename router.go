@@ -0,0 +1,136 @@
+package main
+
+import (
+	"log"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	dgo "github.com/bwmarrin/discordgo"
+)
+
+type InteractionHandler func(s *dgo.Session, i *dgo.InteractionCreate)
+
+// Runs before every routed interaction, returning false stops the routing
+type InteractionMiddleware func(s *dgo.Session, i *dgo.InteractionCreate, route string) bool
+
+type prefixRoute struct {
+	prefix  string
+	handler InteractionHandler
+}
+
+// Dispatches interactions by type, commands and autocompletions by command
+// name, components and modals by custom id prefix.
+type Router struct {
+	commands     map[string]InteractionHandler
+	autocomplete map[string]InteractionHandler
+	components   []prefixRoute
+	modals       []prefixRoute
+	middlewares  []InteractionMiddleware
+}
+
+func NewRouter() *Router {
+	return &Router{
+		commands:     make(map[string]InteractionHandler),
+		autocomplete: make(map[string]InteractionHandler),
+	}
+}
+
+func (r *Router) Use(middleware InteractionMiddleware) {
+	r.middlewares = append(r.middlewares, middleware)
+}
+
+func (r *Router) Command(name string, handler InteractionHandler) {
+	r.commands[name] = handler
+}
+
+func (r *Router) Autocomplete(name string, handler InteractionHandler) {
+	r.autocomplete[name] = handler
+}
+
+// Routes every component whose custom id starts with prefix to handler
+func (r *Router) Component(prefix string, handler InteractionHandler) {
+	r.components = addPrefixRoute(r.components, prefix, handler)
+}
+
+// Routes every modal whose custom id starts with prefix to handler
+func (r *Router) Modal(prefix string, handler InteractionHandler) {
+	r.modals = addPrefixRoute(r.modals, prefix, handler)
+}
+
+// Keeps longer prefixes first, so that the most specific route wins
+func addPrefixRoute(routes []prefixRoute, prefix string, handler InteractionHandler) []prefixRoute {
+	routes = append(routes, prefixRoute{prefix: prefix, handler: handler})
+	sort.SliceStable(routes, func(a, b int) bool {
+		return len(routes[a].prefix) > len(routes[b].prefix)
+	})
+	return routes
+}
+
+func matchPrefixRoute(routes []prefixRoute, customId string) (InteractionHandler, bool) {
+	for _, route := range routes {
+		if strings.HasPrefix(customId, route.prefix) {
+			return route.handler, true
+		}
+	}
+	return nil, false
+}
+
+// The route an interaction goes to: a command name or a custom id
+func interactionRoute(i *dgo.InteractionCreate) string {
+	switch i.Type {
+	case dgo.InteractionApplicationCommand, dgo.InteractionApplicationCommandAutocomplete:
+		return i.ApplicationCommandData().Name
+	case dgo.InteractionMessageComponent:
+		return i.MessageComponentData().CustomID
+	case dgo.InteractionModalSubmit:
+		return i.ModalSubmitData().CustomID
+	}
+	return ""
+}
+
+// Handles i, meant to be registered as a session handler. A panicking handler
+// only fails its own interaction.
+func (r *Router) Handle(s *dgo.Session, i *dgo.InteractionCreate) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Printf("[ROUTER_PANIC]: interaction %s in guild %s: %v\n%s", interactionRoute(i), i.GuildID, recovered, debug.Stack())
+			if i.Type != dgo.InteractionApplicationCommandAutocomplete {
+				// Fails if the handler already responded, a follow up would do then
+				if err := InteractionTextRespond(s, i, NO_PLAYER_AVAILABLE_ERR); err != nil {
+					InteractionErrorUpdate(s, i, NO_PLAYER_AVAILABLE_ERR)
+				}
+			}
+		}
+	}()
+
+	if i.Type == dgo.InteractionPing {
+		return
+	}
+
+	route := interactionRoute(i)
+	for _, middleware := range r.middlewares {
+		if !middleware(s, i, route) {
+			return
+		}
+	}
+
+	var handler InteractionHandler
+	var ok bool
+	switch i.Type {
+	case dgo.InteractionApplicationCommand:
+		handler, ok = r.commands[route]
+	case dgo.InteractionApplicationCommandAutocomplete:
+		handler, ok = r.autocomplete[route]
+	case dgo.InteractionMessageComponent:
+		handler, ok = matchPrefixRoute(r.components, route)
+	case dgo.InteractionModalSubmit:
+		handler, ok = matchPrefixRoute(r.modals, route)
+	}
+
+	if !ok {
+		log.Printf("%s no route for %s interaction: %s\n", i.GuildID, i.Type, route)
+		return
+	}
+	handler(s, i)
+}
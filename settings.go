@@ -0,0 +1,549 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	dgo "github.com/bwmarrin/discordgo"
+)
+
+const (
+	STORE_BUCKET_SETTINGS = "settings"
+
+	IDLE_MODE_TIMEOUT  = "timeout"  // Leaves after idling or being alone for a while
+	IDLE_MODE_ALONE    = "alone"    // Leaves as soon as everyone is gone
+	IDLE_MODE_DISABLED = "disabled" // Never leaves on its own
+
+	DEFAULT_IDLE_TIMEOUT = 300 // Seconds
+	MAX_IDLE_TIMEOUT     = 24 * 60 * 60
+	DEFAULT_VOLUME       = 100 // Percent
+	MAX_VOLUME           = 200
+	VOLUME_MUTED         = -1 // Stored for a volume of 0, which means the default
+	MAX_QUEUE_LENGTH     = 1000
+
+	SETTING_UNKNOWN_ERR = "There's no such setting"
+)
+
+var languagePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// How a guild wants the bot to behave, the zero value being the defaults
+type GuildSettings struct {
+	Volume            int    // Percent, 0 for DEFAULT_VOLUME and VOLUME_MUTED for 0
+	AnnounceChannelID string // Empty for the channel the bot was last used from
+	IdleMode          string // One of IDLE_MODE_*, empty for IDLE_MODE_TIMEOUT
+	IdleTimeout       int    // Seconds, 0 for DEFAULT_IDLE_TIMEOUT
+	StayChannelID     string // Voice channel the bot stays in around the clock, empty if none
+	DJRole            string // Role id, overrides the command line DJ role
+	MaxQueueLength    int    // 0 for no limit
+	AllowedSources    []string
+	Language          string          // Text to speech language, empty for the engine default
+	Loop              LoopMode        // What new playbacks loop
+	Announcements     map[string]bool // Events announced or not, overriding their default
+}
+
+func (gs GuildSettings) VolumePercent() int {
+	switch {
+	case gs.Volume == VOLUME_MUTED:
+		return 0
+	case gs.Volume <= 0:
+		return DEFAULT_VOLUME
+	}
+	return gs.Volume
+}
+
+func (gs GuildSettings) IdleTimeoutSeconds() int {
+	if gs.IdleTimeout <= 0 {
+		return DEFAULT_IDLE_TIMEOUT
+	}
+	return gs.IdleTimeout
+}
+
+// Whether the bot should leave the voice channel after idleSeconds of either
+// playing nothing or playing to nobody, alone telling which one it is now.
+func (gs GuildSettings) ShouldLeave(idleSeconds int, alone bool) bool {
+	if gs.StayChannelID != "" {
+		return false
+	}
+
+	switch gs.IdleMode {
+	case IDLE_MODE_DISABLED:
+		return false
+	case IDLE_MODE_ALONE:
+		if alone {
+			return true
+		}
+	}
+	return idleSeconds >= gs.IdleTimeoutSeconds()
+}
+
+// Per guild settings, cached in memory and persisted into the store
+type Settings struct {
+	mu     sync.Mutex
+	store  *Store // Nil when persistence is disabled
+	guilds map[string]GuildSettings
+}
+
+func NewSettings(store *Store) *Settings {
+	return &Settings{
+		store:  store,
+		guilds: make(map[string]GuildSettings),
+	}
+}
+
+// Must be called with the lock held
+func (st *Settings) load(guildId string) GuildSettings {
+	if settings, ok := st.guilds[guildId]; ok {
+		return settings
+	}
+
+	settings := GuildSettings{}
+	if st.store != nil {
+		if _, err := st.store.Get(STORE_BUCKET_SETTINGS, guildId, &settings); err != nil {
+			log.Printf("[STORE_ERR]: failed loading settings of guild %s: %v\n", guildId, err)
+		}
+	}
+	st.guilds[guildId] = settings
+	return settings
+}
+
+func (st *Settings) Get(guildId string) GuildSettings {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.load(guildId)
+}
+
+// Applies change to the settings of guildId and persists them
+func (st *Settings) Update(guildId string, change func(*GuildSettings)) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	settings := st.load(guildId)
+	change(&settings)
+	st.guilds[guildId] = settings
+
+	if st.store == nil {
+		return nil
+	}
+	return st.store.Put(STORE_BUCKET_SETTINGS, guildId, settings)
+}
+
+// Where announcements go in guildId, the configured channel if there's one
+// or else the channel the bot was last used from.
+func (c *Client) AnnounceChannel(guildId string) string {
+	if channelId := c.Settings.Get(guildId).AnnounceChannelID; channelId != "" {
+		return channelId
+	}
+	return c.ActiveChannel(guildId)
+}
+
+// Makes p follow settings. The loop mode is only a default, it's left as is
+// unless loop is true.
+func (p *Playback) applySettings(settings GuildSettings, loop bool) {
+	p.Volume = settings.VolumePercent()
+	p.Language = settings.Language
+	p.Queue.SetMaxLength(settings.MaxQueueLength)
+	p.Queue.SetAllowedSources(settings.AllowedSources)
+	if loop {
+		p.Loop = settings.Loop
+	}
+}
+
+// Guilds with a voice channel to stay in, by guild id
+func (st *Settings) StayChannels() map[string]string {
+	channels := make(map[string]string)
+	if st.store == nil {
+		return channels
+	}
+
+	err := st.store.ForEach(STORE_BUCKET_SETTINGS, func(key string, value []byte) error {
+		var settings GuildSettings
+		if err := json.Unmarshal(value, &settings); err != nil {
+			log.Printf("[STORE_ERR]: skipping broken settings of guild %s: %v\n", key, err)
+			return nil
+		}
+		if settings.StayChannelID != "" {
+			channels[key] = settings.StayChannelID
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("[STORE_ERR]: failed reading settings:", err)
+	}
+	return channels
+}
+
+// Joins the 24/7 channel of every guild configured with one, unless already
+// connected somewhere in it.
+func (c *Client) JoinStayChannels(s *dgo.Session) {
+	for guildId, channelId := range c.Settings.StayChannels() {
+		if err := c.joinStayChannel(s, guildId, channelId); err != nil {
+			log.Printf("Failed joining 24/7 channel %s in guild %s: %v\n", channelId, guildId, err)
+		}
+	}
+}
+
+// Joins the 24/7 channel of guildId unless the bot is connected there already.
+// The channel may have been deleted or moved since it got configured.
+func (c *Client) joinStayChannel(s *dgo.Session, guildId, channelId string) error {
+	channel, err := s.State.Channel(channelId)
+	if err != nil {
+		if channel, err = s.Channel(channelId); err != nil {
+			return err
+		}
+	}
+	if channel.GuildID != guildId || (channel.Type != dgo.ChannelTypeGuildVoice && channel.Type != dgo.ChannelTypeGuildStageVoice) {
+		return fmt.Errorf("channel %s is not a voice channel of guild %s", channelId, guildId)
+	}
+
+	playback, ok := c.Playback(guildId)
+	if !ok {
+		return nil
+	}
+	playback.voiceLock.Lock()
+	connected := playback.voiceConnection != nil
+	playback.voiceLock.Unlock()
+	if connected {
+		return nil
+	}
+
+	voiceConnection, err := s.ChannelVoiceJoin(guildId, channelId, false, true)
+	if err != nil {
+		return err
+	}
+
+	// Someone might have started playing elsewhere while joining
+	playback.voiceLock.Lock()
+	if playback.voiceConnection == nil {
+		playback.voiceConnection = voiceConnection
+	}
+	playback.voiceLock.Unlock()
+	log.Printf("Joined 24/7 channel %s in guild %s\n", channelId, guildId)
+	return nil
+}
+
+func describeIdleSettings(settings GuildSettings) string {
+	if settings.StayChannelID != "" {
+		return fmt.Sprintf("Staying in <#%s> around the clock", settings.StayChannelID)
+	}
+
+	timeout := describeIdleTimeout(settings.IdleTimeoutSeconds())
+	switch settings.IdleMode {
+	case IDLE_MODE_DISABLED:
+		return "Never leaving on my own"
+	case IDLE_MODE_ALONE:
+		return fmt.Sprintf("Leaving as soon as everyone is gone, or after idling for %s", timeout)
+	}
+	return fmt.Sprintf("Leaving after idling or being alone for %s", timeout)
+}
+
+func describeIdleTimeout(seconds int) string {
+	if seconds%60 != 0 {
+		return FormatDuration(seconds)
+	}
+	if seconds == 60 {
+		return "1 minute"
+	}
+	return fmt.Sprintf("%d minutes", seconds/60)
+}
+
+func (c *Client) configIdle(s *dgo.Session, i *dgo.InteractionCreate, subcommand string, options CommandOptions) {
+	var change func(*GuildSettings)
+	switch subcommand {
+	case "show":
+		InteractionSilentUpdate(s, i, describeIdleSettings(c.Settings.Get(i.GuildID)))
+		return
+	case "timeout":
+		timeout := options.Int("minutes", 0) * 60
+		if timeout <= 0 || timeout > MAX_IDLE_TIMEOUT {
+			InteractionErrorUpdate(s, i, BAD_COMMAND_ARG_ERR)
+			return
+		}
+		change = func(settings *GuildSettings) {
+			settings.IdleMode = IDLE_MODE_TIMEOUT
+			settings.IdleTimeout = timeout
+		}
+	case "alone":
+		change = func(settings *GuildSettings) {
+			settings.IdleMode = IDLE_MODE_ALONE
+		}
+	case "disable":
+		change = func(settings *GuildSettings) {
+			settings.IdleMode = IDLE_MODE_DISABLED
+		}
+	case "stay":
+		channelId := options.ID("channel")
+		change = func(settings *GuildSettings) {
+			settings.StayChannelID = channelId
+		}
+	default:
+		InteractionErrorUpdate(s, i, BAD_COMMAND_ARG_ERR)
+		return
+	}
+
+	if err := c.Settings.Update(i.GuildID, change); err != nil {
+		log.Printf("[STORE_ERR]: failed saving settings of guild %s: %v\n", i.GuildID, err)
+		InteractionErrorUpdate(s, i, NO_PLAYER_AVAILABLE_ERR)
+		return
+	}
+
+	settings := c.Settings.Get(i.GuildID)
+	if settings.StayChannelID != "" {
+		if err := c.joinStayChannel(s, i.GuildID, settings.StayChannelID); err != nil {
+			log.Printf("Failed joining 24/7 channel %s in guild %s: %v\n", settings.StayChannelID, i.GuildID, err)
+			InteractionErrorUpdate(s, i, JOIN_CHANNEL_ERR)
+			return
+		}
+	}
+	InteractionSilentUpdate(s, i, describeIdleSettings(settings))
+}
+
+// A setting /config get, set and reset work with
+type setting struct {
+	Name        string
+	Description string
+	Get         func(c *Client, guildId string, settings GuildSettings) string
+	Set         func(s *dgo.Session, guildId string, value string) (func(*GuildSettings), error) // Validates value, returning how to apply it
+	Reset       func(settings *GuildSettings)
+}
+
+var settingsList = []setting{
+	{
+		Name:        "volume",
+		Description: fmt.Sprintf("Volume percent, from 0 to %d", MAX_VOLUME),
+		Get: func(c *Client, guildId string, settings GuildSettings) string {
+			return fmt.Sprintf("%d%%", settings.VolumePercent())
+		},
+		Set: func(s *dgo.Session, guildId string, value string) (func(*GuildSettings), error) {
+			volume, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+			if err != nil || volume < 0 || volume > MAX_VOLUME {
+				return nil, fmt.Errorf("The volume goes from 0 to %d", MAX_VOLUME)
+			}
+			if volume == 0 {
+				volume = VOLUME_MUTED
+			}
+			return func(settings *GuildSettings) { settings.Volume = volume }, nil
+		},
+		Reset: func(settings *GuildSettings) { settings.Volume = 0 },
+	},
+	{
+		Name:        "announce-channel",
+		Description: "Text channel announcements go to",
+		Get: func(c *Client, guildId string, settings GuildSettings) string {
+			if settings.AnnounceChannelID == "" {
+				return "the channel the bot was last used from"
+			}
+			return "<#" + settings.AnnounceChannelID + ">"
+		},
+		Set: func(s *dgo.Session, guildId string, value string) (func(*GuildSettings), error) {
+			channelId := mentionedID(value)
+			channel, err := s.State.Channel(channelId)
+			if err != nil {
+				channel, err = s.Channel(channelId)
+			}
+			if err != nil || channel.GuildID != guildId ||
+				(channel.Type != dgo.ChannelTypeGuildText && channel.Type != dgo.ChannelTypeGuildNews) {
+				return nil, errors.New("That's not a text channel of this server")
+			}
+			return func(settings *GuildSettings) { settings.AnnounceChannelID = channelId }, nil
+		},
+		Reset: func(settings *GuildSettings) { settings.AnnounceChannelID = "" },
+	},
+	{
+		Name:        "idle-timeout",
+		Description: "Minutes of idling before leaving, see /config idle for more",
+		Get: func(c *Client, guildId string, settings GuildSettings) string {
+			return describeIdleTimeout(settings.IdleTimeoutSeconds())
+		},
+		Set: func(s *dgo.Session, guildId string, value string) (func(*GuildSettings), error) {
+			minutes, err := strconv.Atoi(value)
+			if err != nil || minutes < 1 || minutes*60 > MAX_IDLE_TIMEOUT {
+				return nil, fmt.Errorf("The idle timeout goes from 1 to %d minutes", MAX_IDLE_TIMEOUT/60)
+			}
+			return func(settings *GuildSettings) { settings.IdleTimeout = minutes * 60 }, nil
+		},
+		Reset: func(settings *GuildSettings) { settings.IdleTimeout = 0 },
+	},
+	{
+		Name:        "dj-role",
+		Description: "Role whose members are DJs",
+		Get: func(c *Client, guildId string, settings GuildSettings) string {
+			return c.describeDJRole(guildId)
+		},
+		Set: func(s *dgo.Session, guildId string, value string) (func(*GuildSettings), error) {
+			roleId := mentionedID(value)
+			if _, err := s.State.Role(guildId, roleId); err != nil {
+				return nil, errors.New("That's not a role of this server")
+			}
+			return func(settings *GuildSettings) { settings.DJRole = roleId }, nil
+		},
+		Reset: func(settings *GuildSettings) { settings.DJRole = "" },
+	},
+	{
+		Name:        "max-queue",
+		Description: fmt.Sprintf("Most tracks the queue holds, from 1 to %d", MAX_QUEUE_LENGTH),
+		Get: func(c *Client, guildId string, settings GuildSettings) string {
+			if settings.MaxQueueLength == 0 {
+				return "unlimited"
+			}
+			return strconv.Itoa(settings.MaxQueueLength)
+		},
+		Set: func(s *dgo.Session, guildId string, value string) (func(*GuildSettings), error) {
+			length, err := strconv.Atoi(value)
+			if err != nil || length < 1 || length > MAX_QUEUE_LENGTH {
+				return nil, fmt.Errorf("The queue length goes from 1 to %d", MAX_QUEUE_LENGTH)
+			}
+			return func(settings *GuildSettings) { settings.MaxQueueLength = length }, nil
+		},
+		Reset: func(settings *GuildSettings) { settings.MaxQueueLength = 0 },
+	},
+	{
+		Name:        "sources",
+		Description: "Comma separated sources tracks can come from: " + strings.Join(TrackSources, ", "),
+		Get: func(c *Client, guildId string, settings GuildSettings) string {
+			if len(settings.AllowedSources) == 0 {
+				return "all"
+			}
+			return strings.Join(settings.AllowedSources, ", ")
+		},
+		Set: func(s *dgo.Session, guildId string, value string) (func(*GuildSettings), error) {
+			sources := make([]string, 0, len(TrackSources))
+			for _, source := range strings.Split(strings.ToLower(value), ",") {
+				source = strings.TrimSpace(source)
+				known := false
+				for _, trackSource := range TrackSources {
+					known = known || source == trackSource
+				}
+				if !known {
+					return nil, fmt.Errorf("Sources are any of %s", strings.Join(TrackSources, ", "))
+				}
+				sources = appendMissing(sources, source)
+			}
+			return func(settings *GuildSettings) { settings.AllowedSources = sources }, nil
+		},
+		Reset: func(settings *GuildSettings) { settings.AllowedSources = nil },
+	},
+	{
+		Name:        "language",
+		Description: "Text to speech language, as in en or pt-br",
+		Get: func(c *Client, guildId string, settings GuildSettings) string {
+			if settings.Language == "" {
+				return "default"
+			}
+			return settings.Language
+		},
+		Set: func(s *dgo.Session, guildId string, value string) (func(*GuildSettings), error) {
+			language := strings.ToLower(value)
+			if !languagePattern.MatchString(language) {
+				return nil, errors.New("Languages are codes such as en or pt-br")
+			}
+			return func(settings *GuildSettings) { settings.Language = language }, nil
+		},
+		Reset: func(settings *GuildSettings) { settings.Language = "" },
+	},
+	{
+		Name:        "loop",
+		Description: "What the bot loops when it starts playing: off, track or queue",
+		Get: func(c *Client, guildId string, settings GuildSettings) string {
+			return settings.Loop.String()
+		},
+		Set: func(s *dgo.Session, guildId string, value string) (func(*GuildSettings), error) {
+			mode, err := ParseLoopMode(strings.ToLower(value))
+			if err != nil {
+				return nil, errors.New("The loop modes are off, track and queue")
+			}
+			return func(settings *GuildSettings) { settings.Loop = mode }, nil
+		},
+		Reset: func(settings *GuildSettings) { settings.Loop = LoopOff },
+	},
+}
+
+func findSetting(name string) (setting, bool) {
+	for _, s := range settingsList {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+// The id in a channel, role or user mention, or value itself if it's no mention
+func mentionedID(value string) string {
+	return strings.Trim(strings.TrimSpace(value), "<@&#!>")
+}
+
+func (c *Client) describeSettings(guildId string, name string) string {
+	settings := c.Settings.Get(guildId)
+
+	var sb strings.Builder
+	for _, s := range settingsList {
+		if name == "" || s.Name == name {
+			sb.WriteString(fmt.Sprintf("%s: %s\n", s.Name, s.Get(c, guildId, settings)))
+		}
+		if s.Name == name {
+			sb.WriteString(s.Description)
+		}
+	}
+	return sb.String()
+}
+
+// Handles /config get, set and reset, a reset without a setting resetting
+// every one of settingsList.
+func (c *Client) configSettings(s *dgo.Session, i *dgo.InteractionCreate, subcommand string, options CommandOptions) {
+	name := options.String("setting", "")
+	st, ok := findSetting(name)
+	if name != "" && !ok {
+		InteractionErrorUpdate(s, i, SETTING_UNKNOWN_ERR)
+		return
+	}
+
+	var change func(*GuildSettings)
+	switch subcommand {
+	case "get":
+		InteractionSilentUpdate(s, i, c.describeSettings(i.GuildID, name))
+		return
+	case "set":
+		if !ok {
+			InteractionErrorUpdate(s, i, SETTING_UNKNOWN_ERR)
+			return
+		}
+		// Validated before the update, which holds the settings lock
+		apply, err := st.Set(s, i.GuildID, options.String("value", ""))
+		if err != nil {
+			InteractionErrorUpdate(s, i, err.Error())
+			return
+		}
+		change = apply
+	case "reset":
+		change = func(settings *GuildSettings) {
+			if ok {
+				st.Reset(settings)
+				return
+			}
+			// Idling, staying and announcements have their own commands
+			for _, st := range settingsList {
+				st.Reset(settings)
+			}
+		}
+	default:
+		InteractionErrorUpdate(s, i, BAD_COMMAND_ARG_ERR)
+		return
+	}
+
+	if err := c.Settings.Update(i.GuildID, change); err != nil {
+		log.Printf("[STORE_ERR]: failed saving settings of guild %s: %v\n", i.GuildID, err)
+		InteractionErrorUpdate(s, i, NO_PLAYER_AVAILABLE_ERR)
+		return
+	}
+
+	if p, ok := c.existingPlayback(i.GuildID); ok {
+		p.applySettings(c.Settings.Get(i.GuildID), name == "" || name == "loop")
+		p.queueChanged()
+	}
+	InteractionSilentUpdate(s, i, c.describeSettings(i.GuildID, name))
+}
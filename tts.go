@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"ndmb/enc"
+
+	dgo "github.com/bwmarrin/discordgo"
+)
+
+const (
+	MAX_SPEECH_CHARS  = 300
+	SPEECH_TIMEOUT    = 60 * time.Second
+	TTS_DISABLED_ERR  = "Text to speech is not enabled"
+	TTS_FAILED_ERR    = "Some error occurred while speaking, try again"
+	TTS_TOO_LONG_ERR  = "That's too much to say"
+	TTS_ENGINE_ESPEAK = "espeak"
+	TTS_ENGINE_PIPER  = "piper"
+)
+
+// Turns text into speech, in language if the engine can and it isn't empty.
+// Implementations write a WAV file and return its path, the caller removes
+// it once done.
+type SpeechSynthesizer interface {
+	Synthesize(ctx context.Context, text, language string) (string, error)
+}
+
+type EspeakSynthesizer struct {
+	Path  string
+	Voice string
+}
+
+type PiperSynthesizer struct {
+	Path  string
+	Model string
+}
+
+var Speech SpeechSynthesizer = nil
+var AnnounceTracks bool = false
+
+// Streams the WAV file at wav into voiceConnection, returning once it's over
+// or ctx is done
+var playSpeech = func(ctx context.Context, voiceConnection *dgo.VoiceConnection, wav string) {
+	voice := enc.NewEnc(enc.DefaultOptions(FfmpegPath))
+	done := make(chan struct{})
+	voice.Listen(enc.PlayerEventTrackEnded, func(event enc.PlayerEvent) {
+		close(done)
+	})
+
+	errCh := make(chan error, 8)
+	cmdCh := make(chan enc.Command)
+	go voice.GetOpusFrames(wav, enc.DefaultOptions(FfmpegPath), voiceConnection.OpusSend, errCh, cmdCh, make(chan enc.Response))
+
+	for {
+		select {
+		case <-done:
+			return
+		case err := <-errCh:
+			// The encoder may give up without ever ending the track
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				log.Println("[TTS_ERR]:", err)
+				return
+			}
+		case <-ctx.Done():
+			select {
+			case cmdCh <- enc.CommandStop{}:
+				<-done
+			case <-done:
+			}
+			return
+		}
+	}
+}
+
+func SetSpeechSynthesizer(synthesizer SpeechSynthesizer, announceTracks bool) {
+	Speech = synthesizer
+	AnnounceTracks = announceTracks && synthesizer != nil
+}
+
+func NewSpeechSynthesizer(engine, path, model string) (SpeechSynthesizer, error) {
+	switch engine {
+	case TTS_ENGINE_ESPEAK:
+		return EspeakSynthesizer{Path: path, Voice: model}, nil
+	case TTS_ENGINE_PIPER:
+		if model == "" {
+			return nil, fmt.Errorf("piper needs a voice model")
+		}
+		return PiperSynthesizer{Path: path, Model: model}, nil
+	}
+	return nil, fmt.Errorf("unknown tts engine: %s", engine)
+}
+
+// Espeak voices are named after their language, which overrides the voice
+func (e EspeakSynthesizer) Synthesize(ctx context.Context, text, language string) (string, error) {
+	voice := e.Voice
+	if language != "" {
+		voice = language
+	}
+
+	args := []string{"--stdin"}
+	if voice != "" {
+		args = append(args, "-v", voice)
+	}
+	return synthesizeWav(ctx, e.Path, text, func(out string) []string {
+		return append(args, "-w", out)
+	})
+}
+
+// Piper speaks the language of its model whatever language is asked for
+func (p PiperSynthesizer) Synthesize(ctx context.Context, text, language string) (string, error) {
+	return synthesizeWav(ctx, p.Path, text, func(out string) []string {
+		return []string{"--model", p.Model, "--output_file", out}
+	})
+}
+
+// Runs a tts engine reading text from stdin and writing a WAV file at the
+// path the returned arguments point to.
+func synthesizeWav(ctx context.Context, enginePath, text string, args func(out string) []string) (string, error) {
+	f, err := os.CreateTemp("", "godmb-tts-*.wav")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+
+	cmd := exec.CommandContext(ctx, enginePath, args(f.Name())...)
+	cmd.Stdin = strings.NewReader(text)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("%s failed: %v (output: %s)", enginePath, err, out)
+	}
+
+	return f.Name(), nil
+}
+
+// Pauses whatever is playing, speaks text into the voice channel and then
+// resumes the paused track.
+func (p *Playback) Say(text string) error {
+	if Speech == nil {
+		return fmt.Errorf("no speech synthesizer available")
+	}
+	voiceConnection := p.VoiceConnection()
+	if voiceConnection == nil {
+		return fmt.Errorf("no voice connection available")
+	}
+
+	p.speechLock.Lock()
+	defer p.speechLock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), SPEECH_TIMEOUT)
+	defer cancel()
+
+	wav, err := Speech.Synthesize(ctx, text, p.Language)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(wav)
+
+	wasPlaying := p.Player.State == enc.PlayerStatePlaying
+	if wasPlaying {
+		p.CommandChannel <- enc.CommandPause{}
+	}
+
+	playSpeech(ctx, voiceConnection, wav)
+
+	if wasPlaying {
+		p.CommandChannel <- enc.CommandResume{}
+	}
+	return nil
+}
+
+func (c *Client) SayCommand(s *dgo.Session, i *dgo.InteractionCreate) {
+	if err := InteractionRespondDeferred(s, i); err != nil {
+		log.Printf(
+			"Failed sending deferred response into guild: %s, error: %s",
+			i.GuildID,
+			err,
+		)
+	}
+
+	text := InteractionOptions(i).String("text", "")
+
+	clientErr := ""
+	var playback *Playback
+	if Speech == nil {
+		clientErr = TTS_DISABLED_ERR
+	} else if len([]rune(text)) > MAX_SPEECH_CHARS {
+		clientErr = TTS_TOO_LONG_ERR
+	} else if p, ok := c.Playback(i.GuildID); !ok {
+		clientErr = NO_PLAYER_AVAILABLE_ERR
+	} else {
+		playback = p
+	}
+
+	if clientErr == "" && playback.VoiceConnection() == nil {
+		voiceConnection, err := JoinUserVoiceChannel(s, i.GuildID, i.Member.User.ID)
+		if err != nil {
+			clientErr = JOIN_CHANNEL_ERR
+		} else {
+			playback.setVoiceConnection(voiceConnection)
+		}
+	}
+
+	if clientErr == "" {
+		if err := playback.Say(text); err != nil {
+			log.Println("[TTS_ERR]:", err)
+			clientErr = TTS_FAILED_ERR
+		}
+	}
+
+	if clientErr != "" {
+		if err := InteractionTextUpdate(s, i, clientErr); err != nil {
+			log.Printf("Failed sending client error message to guild %s, client_error: %s, error: %s",
+				i.GuildID,
+				clientErr,
+				err,
+			)
+		}
+		return
+	}
+
+	msg := fmt.Sprintf("Said: %s", text)
+	if err := InteractionTextUpdate(s, i, msg); err != nil {
+		log.Printf("Failed sending client message to guild %s, client_message: %s, error: %s",
+			i.GuildID,
+			msg,
+			err,
+		)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"ndmb/enc"
+
+	dgo "github.com/bwmarrin/discordgo"
+)
+
+// Writes a second of silence as a 16 bit mono WAV instead of running an engine
+type stubSynthesizer struct {
+	texts     []string
+	languages []string
+}
+
+func (s *stubSynthesizer) Synthesize(ctx context.Context, text, language string) (string, error) {
+	s.texts = append(s.texts, text)
+	s.languages = append(s.languages, language)
+
+	f, err := os.CreateTemp("", "godmb-tts-test-*.wav")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	const sampleRate = 8000
+	samples := make([]int16, sampleRate)
+	header := []interface{}{
+		[4]byte{'R', 'I', 'F', 'F'}, uint32(36 + 2*len(samples)), [4]byte{'W', 'A', 'V', 'E'},
+		[4]byte{'f', 'm', 't', ' '}, uint32(16), uint16(1), uint16(1), uint32(sampleRate), uint32(2 * sampleRate), uint16(2), uint16(16),
+		[4]byte{'d', 'a', 't', 'a'}, uint32(2 * len(samples)),
+	}
+	for _, field := range append(header, samples) {
+		if err := binary.Write(f, binary.LittleEndian, field); err != nil {
+			os.Remove(f.Name())
+			return "", err
+		}
+	}
+	return f.Name(), nil
+}
+
+func stubSpeech(t *testing.T) (*stubSynthesizer, *[][]byte) {
+	t.Helper()
+	synthesizer := &stubSynthesizer{}
+	played := make([][]byte, 0)
+
+	previousSpeech, previousAnnounce, previousPlay := Speech, AnnounceTracks, playSpeech
+	SetSpeechSynthesizer(synthesizer, false)
+	playSpeech = func(ctx context.Context, voiceConnection *dgo.VoiceConnection, wav string) {
+		data, err := os.ReadFile(wav)
+		if err != nil {
+			t.Errorf("reading the synthesized speech: %v", err)
+		}
+		played = append(played, data)
+	}
+	t.Cleanup(func() {
+		Speech, AnnounceTracks, playSpeech = previousSpeech, previousAnnounce, previousPlay
+	})
+
+	return synthesizer, &played
+}
+
+func speechPlayback(state enc.PlayerState) *Playback {
+	return &Playback{
+		Player:          &enc.Enc{State: state},
+		Language:        "fr",
+		CommandChannel:  make(chan enc.Command, 2),
+		voiceConnection: &dgo.VoiceConnection{},
+	}
+}
+
+func TestSayPlaysSynthesizedSpeech(t *testing.T) {
+	synthesizer, played := stubSpeech(t)
+	p := speechPlayback(enc.PlayerStateIdle)
+
+	if err := p.Say("hello there"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(synthesizer.texts) != 1 || synthesizer.texts[0] != "hello there" || synthesizer.languages[0] != "fr" {
+		t.Errorf("synthesized %q in %q", synthesizer.texts, synthesizer.languages)
+	}
+	if len(*played) != 1 || !bytes.HasPrefix((*played)[0], []byte("RIFF")) || !bytes.Contains((*played)[0][:16], []byte("WAVE")) {
+		t.Fatalf("played %d speeches, want one WAV", len(*played))
+	}
+	if len(p.CommandChannel) != 0 {
+		t.Errorf("sent %d commands to an idle player", len(p.CommandChannel))
+	}
+}
+
+func TestSayPausesPlayingTrack(t *testing.T) {
+	_, played := stubSpeech(t)
+	p := speechPlayback(enc.PlayerStatePlaying)
+
+	if err := p.Say("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if len(*played) != 1 {
+		t.Fatalf("played %d speeches, want 1", len(*played))
+	}
+
+	if _, ok := (<-p.CommandChannel).(enc.CommandPause); !ok {
+		t.Error("the track wasn't paused first")
+	}
+	if _, ok := (<-p.CommandChannel).(enc.CommandResume); !ok {
+		t.Error("the track wasn't resumed after")
+	}
+}
+
+func TestSayFailures(t *testing.T) {
+	_, played := stubSpeech(t)
+
+	p := speechPlayback(enc.PlayerStateIdle)
+	p.setVoiceConnection(nil)
+	if err := p.Say("hello"); err == nil {
+		t.Error("spoke without voice connection")
+	}
+
+	Speech = nil
+	if err := speechPlayback(enc.PlayerStateIdle).Say("hello"); err == nil {
+		t.Error("spoke without synthesizer")
+	}
+
+	if len(*played) != 0 {
+		t.Errorf("played %d speeches, want none", len(*played))
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"fmt"
+	"log"
+
 	dgo "github.com/bwmarrin/discordgo"
 )
 
@@ -13,6 +16,39 @@ func InteractionTextRespond(s *dgo.Session, i *dgo.InteractionCreate, message st
 	})
 }
 
+// Replies with msg visible only to the interaction author, logging failures
+func InteractionEphemeralRespond(s *dgo.Session, i *dgo.InteractionCreate, msg string) {
+	err := s.InteractionRespond(i.Interaction, &dgo.InteractionResponse{
+		Type: dgo.InteractionResponseChannelMessageWithSource,
+		Data: &dgo.InteractionResponseData{
+			Content: msg,
+			Flags:   dgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("Failed sending client message to guild %s, client_message: %s, error: %s",
+			i.GuildID,
+			msg,
+			err,
+		)
+	}
+}
+
+// Like InteractionMessageUpdate, without notifying any mentioned role or user
+func InteractionSilentUpdate(s *dgo.Session, i *dgo.InteractionCreate, msg string) {
+	_, err := s.InteractionResponseEdit(i.Interaction, &dgo.WebhookEdit{
+		Content:         &msg,
+		AllowedMentions: &dgo.MessageAllowedMentions{},
+	})
+	if err != nil {
+		log.Printf("Failed sending client message to guild %s, client_message: %s, error: %s",
+			i.GuildID,
+			msg,
+			err,
+		)
+	}
+}
+
 func InteractionTextUpdate(s *dgo.Session, i *dgo.InteractionCreate, message string) error {
 	_, err := s.InteractionResponseEdit(i.Interaction, &dgo.WebhookEdit{
 		Content: &message,
@@ -20,9 +56,46 @@ func InteractionTextUpdate(s *dgo.Session, i *dgo.InteractionCreate, message str
 	return err
 }
 
+// Reports clientErr as the deferred interaction response, logging failures
+func InteractionErrorUpdate(s *dgo.Session, i *dgo.InteractionCreate, clientErr string) {
+	if err := InteractionTextUpdate(s, i, clientErr); err != nil {
+		log.Printf("Failed sending client error message to guild %s, client_error: %s, error: %s",
+			i.GuildID,
+			clientErr,
+			err,
+		)
+	}
+}
+
+// Sends msg as the deferred interaction response, logging failures
+func InteractionMessageUpdate(s *dgo.Session, i *dgo.InteractionCreate, msg string) {
+	if err := InteractionTextUpdate(s, i, msg); err != nil {
+		log.Printf("Failed sending client message to guild %s, client_message: %s, error: %s",
+			i.GuildID,
+			msg,
+			err,
+		)
+	}
+}
+
 func InteractionRespondDeferred(s *dgo.Session, i *dgo.InteractionCreate) error {
 	return s.InteractionRespond(i.Interaction, &dgo.InteractionResponse{
 		Type: dgo.InteractionResponseDeferredChannelMessageWithSource,
 		//Data: &dgo.InteractionResponseData{},
 	})
 }
+
+// Formats seconds as m:ss, or h:mm:ss for anything longer than an hour
+func FormatDuration(seconds int) string {
+	if seconds < 0 {
+		seconds = 0
+	}
+
+	hours := seconds / 3600
+	minutes := (seconds % 3600) / 60
+	seconds = seconds % 60
+	if hours > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", hours, minutes, seconds)
+	}
+	return fmt.Sprintf("%d:%02d", minutes, seconds)
+}
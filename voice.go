@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"ndmb/enc"
+
+	dgo "github.com/bwmarrin/discordgo"
+)
+
+const (
+	VOICE_DOWN_TICKS           = 2 // Watchdog ticks discordgo gets to reconnect on its own
+	VOICE_RECONNECT_ATTEMPTS   = 5
+	VOICE_RECONNECT_BACKOFF    = time.Second // Doubles after every failed attempt
+	VOICE_RECONNECT_FAILED_ERR = "Lost the voice connection to <#%s> and couldn't get it back, use /play to start again"
+)
+
+// Listeners left in the voice channel of the bot, as of the last voice state
+// update of its guild.
+func (p *Playback) Listeners() int {
+	p.voiceLock.Lock()
+	defer p.voiceLock.Unlock()
+	return p.listeners
+}
+
+// The voice connection p streams into, nil while it has none
+func (p *Playback) VoiceConnection() *dgo.VoiceConnection {
+	p.voiceLock.Lock()
+	defer p.voiceLock.Unlock()
+	return p.voiceConnection
+}
+
+// Replaces the voice connection of p, returning the previous one
+func (p *Playback) setVoiceConnection(voiceConnection *dgo.VoiceConnection) *dgo.VoiceConnection {
+	p.voiceLock.Lock()
+	defer p.voiceLock.Unlock()
+	previous := p.voiceConnection
+	p.voiceConnection = voiceConnection
+	return previous
+}
+
+// The playback of guildId if there's one already, it never gets created here
+func (c *Client) existingPlayback(guildId string) (*Playback, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	p, ok := c.players[guildId]
+	return p, ok
+}
+
+// Keeps track of the listeners in the voice channel of the bot, pausing the
+// playback while nobody is there. Also follows the bot when it gets moved to
+// another channel or disconnected by someone else.
+func (c *Client) OnVoiceStateUpdate(s *dgo.Session, v *dgo.VoiceStateUpdate) {
+	p, ok := c.existingPlayback(v.GuildID)
+	if !ok {
+		return
+	}
+
+	if v.UserID == s.State.User.ID {
+		if v.ChannelID == "" {
+			// Someone disconnected the bot, it mustn't come back on its own
+			p.voiceLock.Lock()
+			p.reconnecting = false
+			p.voiceLock.Unlock()
+
+			c.releaseVoice(s, p)
+			// The final snapshot got saved already, this one would lose the
+			// channel and the track to resume
+			if !c.ShuttingDown() {
+				c.SavePlayback(p)
+			}
+			return
+		}
+
+		// The connection stays the same when moved, only its channel changes.
+		// While reconnecting, the connection is handed over once it's ready.
+		p.voiceLock.Lock()
+		if voiceConnection, ok := s.VoiceConnections[v.GuildID]; ok && !p.reconnecting {
+			p.voiceConnection = voiceConnection
+		}
+		p.voiceLock.Unlock()
+		if v.BeforeUpdate != nil && v.BeforeUpdate.ChannelID != "" && v.BeforeUpdate.ChannelID != v.ChannelID {
+			log.Printf("Moved from channel %s to %s in guild %s\n", v.BeforeUpdate.ChannelID, v.ChannelID, v.GuildID)
+		}
+		c.updateListeners(s, p, v.ChannelID)
+		return
+	}
+
+	if voiceConnection := p.VoiceConnection(); voiceConnection != nil {
+		c.updateListeners(s, p, voiceConnection.ChannelID)
+	}
+}
+
+// Counts the listeners of channelId, pausing or leaving as configured when
+// they're all gone and resuming when one is back if the pause was automatic.
+func (c *Client) updateListeners(s *dgo.Session, p *Playback, channelId string) {
+	listeners := len(VoiceChannelListeners(s, p.GuildID, channelId))
+
+	if listeners == 0 && c.Settings.Get(p.GuildID).ShouldLeave(0, true) {
+		if err := c.leaveIdle(s, p.GuildID, true); err == nil {
+			return
+		}
+	}
+
+	p.voiceLock.Lock()
+	p.listeners = listeners
+	pause := listeners == 0 && p.Player.State == enc.PlayerStatePlaying
+	resume := listeners > 0 && p.autoPaused && p.Player.State == enc.PlayerStatePaused
+	if pause {
+		p.autoPaused = true
+	}
+	p.voiceLock.Unlock()
+
+	if pause {
+		log.Printf("Pausing in guild %s, no one is listening\n", p.GuildID)
+		c.Podcasts.Snapshot(p)
+		if !p.send(enc.CommandPause{}) {
+			log.Printf("Failed pausing in guild %s, the player didn't answer\n", p.GuildID)
+			p.voiceLock.Lock()
+			p.autoPaused = false
+			p.voiceLock.Unlock()
+		}
+	} else if resume {
+		log.Printf("Resuming in guild %s, someone is listening again\n", p.GuildID)
+		if !p.send(enc.CommandResume{}) {
+			log.Printf("Failed resuming in guild %s, the player didn't answer\n", p.GuildID)
+		}
+	}
+}
+
+// Stops p and drops its voice connection, which is no longer usable once the
+// bot got disconnected or removed from the guild. The queue is kept.
+func (c *Client) releaseVoice(s *dgo.Session, p *Playback) {
+	// Keeps the track ended listener from playing anything else
+	p.voiceLock.Lock()
+	voiceConnection := p.voiceConnection
+	p.voiceConnection = nil
+	p.listeners = 0
+	p.autoPaused = false
+	p.voiceLock.Unlock()
+	if voiceConnection == nil {
+		return
+	}
+
+	if p.Player.State == enc.PlayerStatePlaying || p.Player.State == enc.PlayerStatePaused {
+		c.Podcasts.Snapshot(p)
+		if !p.send(enc.CommandStop{}) {
+			log.Printf("Failed stopping in guild %s, the player didn't answer\n", p.GuildID)
+		}
+	}
+
+	// Closes what's left of the connection when someone else disconnected the
+	// bot, it's gone already otherwise.
+	if _, ok := s.VoiceConnections[p.GuildID]; ok {
+		if err := voiceConnection.Disconnect(); err != nil {
+			log.Printf("Failed disconnecting from guild %s: %v\n", p.GuildID, err)
+		}
+	}
+	log.Printf("Disconnected from voice in guild %s\n", p.GuildID)
+}
+
+// Whether the voice connection can send audio. It can't while the voice
+// websocket is down, even if the connection object is still around.
+func voiceReady(voiceConnection *dgo.VoiceConnection) bool {
+	voiceConnection.RLock()
+	defer voiceConnection.RUnlock()
+	return voiceConnection.Ready
+}
+
+// Periodically checks the voice connections, rejoining the channels of the
+// ones that stayed down for VOICE_DOWN_TICKS.
+func (c *Client) StartVoiceWatchdog(s *dgo.Session, tickEvery int) chan struct{} {
+	stop := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(tickEvery) * time.Second)
+		defer ticker.Stop()
+		downTicks := make(map[string]int)
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			for _, playback := range c.Playbacks() {
+				guildId := playback.GuildID
+				voiceConnection := playback.VoiceConnection()
+				if voiceConnection == nil || voiceReady(voiceConnection) {
+					downTicks[guildId] = 0
+					continue
+				}
+
+				downTicks[guildId]++
+				if downTicks[guildId] < VOICE_DOWN_TICKS {
+					continue
+				}
+				downTicks[guildId] = 0
+
+				playback.voiceLock.Lock()
+				reconnecting := playback.reconnecting
+				playback.reconnecting = true
+				playback.voiceLock.Unlock()
+				if !reconnecting {
+					go c.reconnectVoice(s, playback, voiceConnection)
+				}
+			}
+		}
+	}()
+
+	return stop
+}
+
+// Rejoins the channel of the dropped voice connection with an exponential
+// backoff, restarting the current track where it stopped being sent. The
+// frames encoded in the meantime are discarded, encoding starts over from
+// that position.
+func (c *Client) reconnectVoice(s *dgo.Session, p *Playback, dropped *dgo.VoiceConnection) {
+	guildId, channelId := p.GuildID, dropped.ChannelID
+	log.Printf("Voice connection to channel %s in guild %s is down, reconnecting\n", channelId, guildId)
+
+	track := p.Track
+	state := p.Player.State
+	position, playing := p.Position()
+	track.Seek = position
+
+	p.voiceLock.Lock()
+	paused := state == enc.PlayerStatePaused && !p.autoPaused
+	p.voiceLock.Unlock()
+
+	// Keeps commands and the track ended listener away until it's back
+	p.setVoiceConnection(nil)
+	if playing && !p.send(enc.CommandStop{}) {
+		log.Printf("Failed stopping in guild %s, the player didn't answer\n", guildId)
+	}
+
+	var voiceConnection *dgo.VoiceConnection
+	backoff := VOICE_RECONNECT_BACKOFF
+	for attempt := 1; attempt <= VOICE_RECONNECT_ATTEMPTS; attempt++ {
+		time.Sleep(backoff)
+		backoff *= 2
+
+		p.voiceLock.Lock()
+		reconnecting := p.reconnecting
+		p.voiceLock.Unlock()
+		if _, ok := c.existingPlayback(guildId); !ok || !reconnecting {
+			log.Printf("Gave up reconnecting in guild %s, the bot got disconnected\n", guildId)
+			return
+		}
+
+		vc, err := s.ChannelVoiceJoin(guildId, channelId, false, true)
+		if err == nil {
+			voiceConnection = vc
+			break
+		}
+		log.Printf("Failed reconnecting to channel %s in guild %s (attempt %d of %d): %v\n", channelId, guildId, attempt, VOICE_RECONNECT_ATTEMPTS, err)
+	}
+
+	p.voiceLock.Lock()
+	p.reconnecting = false
+	p.voiceLock.Unlock()
+
+	if voiceConnection == nil {
+		if vc, ok := s.VoiceConnections[guildId]; ok {
+			if err := vc.Disconnect(); err != nil {
+				log.Printf("Failed disconnecting from guild %s: %v\n", guildId, err)
+			}
+		}
+
+		c.Announce(s, guildId, EVENT_RECONNECT, fmt.Sprintf(VOICE_RECONNECT_FAILED_ERR, channelId))
+		return
+	}
+
+	log.Printf("Reconnected to channel %s in guild %s\n", channelId, guildId)
+	c.Announce(s, guildId, EVENT_RECONNECT, fmt.Sprintf("Got the voice connection to <#%s> back after it dropped", channelId))
+	p.setVoiceConnection(voiceConnection)
+	if playing {
+		p.restart(track)
+		if paused && !p.send(enc.CommandPause{}) {
+			log.Printf("Failed pausing in guild %s, the player didn't answer\n", guildId)
+		}
+	}
+	c.updateListeners(s, p, channelId)
+}
+
+// Plays track again into the voice connection, without recording nor
+// announcing it as a new track
+func (p *Playback) restart(track Track) {
+	p.Track = track
+	p.stream(track)
+}
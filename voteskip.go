@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	dgo "github.com/bwmarrin/discordgo"
+)
+
+const (
+	VOTE_SKIP_ID = "vote_skip:"
+
+	VOTE_SKIP_LISTENER_ERR = "Only listeners in the bot's voice channel can vote to skip"
+	VOTE_SKIP_VOTED_ERR    = "You already voted to skip this track"
+	VOTE_SKIP_OVER_ERR     = "This vote is over, the track already changed"
+)
+
+// Fraction of the listeners needed to skip a track, 0 lets anyone skip
+// straight away. Set from the command line flags.
+var VoteSkipFraction float64
+
+// Name or id of the DJ role of guilds without one configured
+var DJRole string
+
+// The votes to skip the current track. Round changes with every track, so
+// that votes for a previous track don't count anymore.
+type SkipVotes struct {
+	Round  int
+	voters map[string]bool
+}
+
+// Must be called whenever the current track changes
+func (p *Playback) resetSkipVotes() {
+	p.skipVotesLock.Lock()
+	p.skipVotes.Round++
+	p.skipVotes.voters = make(map[string]bool)
+	p.skipVotesLock.Unlock()
+}
+
+// Registers the vote of userId for round, returning the votes of the current
+// listeners and how many are needed to skip.
+func (p *Playback) voteSkip(round int, userId string, listeners []string) (int, int, error) {
+	p.skipVotesLock.Lock()
+	defer p.skipVotesLock.Unlock()
+
+	if round != p.skipVotes.Round {
+		return 0, 0, errors.New(VOTE_SKIP_OVER_ERR)
+	}
+	if p.skipVotes.voters[userId] {
+		return 0, 0, errors.New(VOTE_SKIP_VOTED_ERR)
+	}
+
+	listening := false
+	for _, id := range listeners {
+		listening = listening || id == userId
+	}
+	if !listening {
+		return 0, 0, errors.New(VOTE_SKIP_LISTENER_ERR)
+	}
+	p.skipVotes.voters[userId] = true
+
+	// Votes of those who left the channel meanwhile don't count
+	votes := 0
+	for _, id := range listeners {
+		if p.skipVotes.voters[id] {
+			votes++
+		}
+	}
+
+	required := int(math.Ceil(VoteSkipFraction * float64(len(listeners))))
+	if required < 1 {
+		required = 1
+	}
+	return votes, required, nil
+}
+
+// The non bot users currently in the given voice channel
+func VoiceChannelListeners(s *dgo.Session, guildId, channelId string) []string {
+	guild, err := s.State.Guild(guildId)
+	if err != nil {
+		return nil
+	}
+
+	listeners := make([]string, 0, len(guild.VoiceStates))
+	for _, state := range guild.VoiceStates {
+		if state.ChannelID != channelId {
+			continue
+		}
+
+		member := state.Member
+		if member == nil {
+			member, _ = s.State.Member(guildId, state.UserID)
+		}
+		if member != nil && member.User != nil && member.User.Bot {
+			continue
+		}
+		listeners = append(listeners, state.UserID)
+	}
+	return listeners
+}
+
+// Whether member gets to skip the current track without a vote
+func (c *Client) CanSkip(s *dgo.Session, p *Playback, member *dgo.Member) bool {
+	return VoteSkipFraction <= 0 || p.RequesterID == member.User.ID || c.IsDJ(s, p.GuildID, member)
+}
+
+func (p *Playback) voteSkipMessage(votes, required int) (string, []dgo.MessageComponent) {
+	p.skipVotesLock.Lock()
+	round := p.skipVotes.Round
+	p.skipVotesLock.Unlock()
+
+	msg := fmt.Sprintf("Vote to skip %s: %d/%d", p.Title, votes, required)
+	components := []dgo.MessageComponent{
+		dgo.ActionsRow{
+			Components: []dgo.MessageComponent{
+				dgo.Button{
+					Label:    "Vote skip",
+					Style:    dgo.PrimaryButton,
+					CustomID: VOTE_SKIP_ID + strconv.Itoa(round),
+				},
+			},
+		},
+	}
+	return msg, components
+}
+
+// Casts the vote of whoever sent the interaction, skipping the track once
+// enough listeners agree. Returns the message describing the vote outcome.
+func (c *Client) castSkipVote(s *dgo.Session, i *dgo.InteractionCreate, playback *Playback, round int) (string, []dgo.MessageComponent, error) {
+	voiceConnection := playback.VoiceConnection()
+	if voiceConnection == nil {
+		return "", nil, errors.New(NO_VOICE_CONNECTION_ERR)
+	}
+	listeners := VoiceChannelListeners(s, i.GuildID, voiceConnection.ChannelID)
+	votes, required, err := playback.voteSkip(round, i.Member.User.ID, listeners)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if votes < required {
+		msg, components := playback.voteSkipMessage(votes, required)
+		return msg, components, nil
+	}
+
+	c.Podcasts.Snapshot(playback)
+	track, ok := playback.Skip()
+	if !ok {
+		return "", nil, errors.New(QUEUE_EMPTY_ERR)
+	}
+	return "Vote passed, " + playback.NowPlayingMessage(track), []dgo.MessageComponent{}, nil
+}
+
+// Handles /next from someone who can't skip straight away
+func (c *Client) voteSkipCommand(s *dgo.Session, i *dgo.InteractionCreate, playback *Playback) {
+	playback.skipVotesLock.Lock()
+	round := playback.skipVotes.Round
+	playback.skipVotesLock.Unlock()
+
+	msg, components, err := c.castSkipVote(s, i, playback, round)
+	if err != nil {
+		InteractionErrorUpdate(s, i, err.Error())
+		return
+	}
+
+	_, err = s.InteractionResponseEdit(i.Interaction, &dgo.WebhookEdit{
+		Content:    &msg,
+		Components: &components,
+	})
+	if err != nil {
+		log.Printf("Failed sending vote skip message to guild %s, error: %s", i.GuildID, err)
+	}
+}
+
+func (c *Client) VoteSkipComponent(s *dgo.Session, i *dgo.InteractionCreate) {
+	playback, ok := c.Playback(i.GuildID)
+	if !ok || playback.VoiceConnection() == nil {
+		ReportGenericError(NO_VOICE_CONNECTION_ERR, s, i)
+		return
+	}
+
+	round, err := strconv.Atoi(strings.TrimPrefix(i.MessageComponentData().CustomID, VOTE_SKIP_ID))
+	if err != nil {
+		ReportGenericError(BAD_COMMAND_ARG_ERR, s, i)
+		return
+	}
+
+	msg, components, err := c.castSkipVote(s, i, playback, round)
+	if err != nil {
+		InteractionEphemeralRespond(s, i, err.Error())
+		return
+	}
+
+	err = s.InteractionRespond(i.Interaction, &dgo.InteractionResponse{
+		Type: dgo.InteractionResponseUpdateMessage,
+		Data: &dgo.InteractionResponseData{
+			Content:    msg,
+			Components: components,
+		},
+	})
+	if err != nil {
+		log.Printf("Failed updating vote skip message in guild %s, error: %s", i.GuildID, err)
+	}
+}
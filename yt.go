@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os/exec"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -888,6 +894,17 @@ type YTDLPOut struct {
 
 var YTDLPPath string = ""
 
+var (
+	// Held for reading by every extraction and for writing by updates, so
+	// that the binary is never replaced while a resolution is in flight.
+	ytdlpLock sync.RWMutex
+
+	ytdlpVersion        atomic.Value // string
+	ytdlpFailures       int32
+	ytdlpUpdateRequests = make(chan struct{}, 1)
+	ytdlpMaxFailures    int32
+)
+
 func SetYtdlpPath(path string) {
 	YTDLPPath = path
 	c := exec.Command(YTDLPPath)
@@ -958,9 +975,9 @@ type YTOmbedResponse struct {
 	HTML            string `json:"html,omitempty"`
 }
 
-func ResolveVideoTitle(URL string) (string, error) {
+func ResolveVideoTitle(ctx context.Context, URL string) (string, error) {
 	client := &http.Client{}
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://www.youtube.com/oembed?format=json&url=%s", URL), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("https://www.youtube.com/oembed?format=json&url=%s", URL), nil)
 	if err != nil {
 		return "", fmt.Errorf("Failed creating youtube request at: %s (got err: %v)", URL, err)
 	}
@@ -1007,7 +1024,18 @@ func ResolveVideoTitle(URL string) (string, error) {
 	return result.Title, nil
 }
 
-func YoutubeMediaUrl(videoUrl string) (string, error) {
+// What yt-dlp tells about a video, on top of its playable media url
+type YoutubeMedia struct {
+	MediaURL  string
+	Title     string
+	Duration  int // Seconds
+	Thumbnail string
+	Uploader  string
+}
+
+func YoutubeMediaInfo(ctx context.Context, videoUrl string) (YoutubeMedia, error) {
+	media := YoutubeMedia{}
+
 	args := []string{
 		"--dump-single-json",
 		"--no-warnings",
@@ -1017,7 +1045,11 @@ func YoutubeMediaUrl(videoUrl string) (string, error) {
 		videoUrl,
 	}
 
-	cmd := exec.Command(
+	ytdlpLock.RLock()
+	defer ytdlpLock.RUnlock()
+
+	cmd := exec.CommandContext(
+		ctx,
 		YTDLPPath,
 		args...,
 	)
@@ -1028,24 +1060,75 @@ func YoutubeMediaUrl(videoUrl string) (string, error) {
 
 	stdout, err := cmd.Output()
 	if err != nil {
-		return "", err
+		reportYTDLPFailure(ctx)
+		return media, err
 	}
 
 	var ytdlOutput YTDLPOut
 	if err := json.Unmarshal(stdout, &ytdlOutput); err != nil {
 		log.Println(err)
-		return "", err
+		reportYTDLPFailure(ctx)
+		return media, err
 	}
 
+	if ytdlOutput.Version.Version != "" {
+		ytdlpVersion.Store(ytdlOutput.Version.Version)
+	}
+
+	media.Title = ytdlOutput.Title
+	media.Duration = ytdlOutput.Duration
+	media.Thumbnail = ytdlOutput.Thumbnail
+	media.Uploader = ytdlOutput.Uploader
+
 	for _, format := range ytdlOutput.Formats {
 		if format.Vcodec == "none" && format.Acodec == "opus" {
-			return format.URL, nil
+			atomic.StoreInt32(&ytdlpFailures, 0)
+			media.MediaURL = format.URL
+			return media, nil
 		}
 	}
 
 	err = fmt.Errorf("no media url found")
 	log.Println(err)
-	return "", err
+	reportYTDLPFailure(ctx)
+	return media, err
+}
+
+// Counts a failed extraction and requests an update once too many of them
+// happened in a row. Extractions cut short by ctx say nothing about yt-dlp.
+func reportYTDLPFailure(ctx context.Context) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	failures := atomic.AddInt32(&ytdlpFailures, 1)
+	maxFailures := atomic.LoadInt32(&ytdlpMaxFailures)
+	if maxFailures > 0 && failures >= maxFailures {
+		select {
+		case ytdlpUpdateRequests <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Returns the last yt-dlp version seen, either from an extraction output or
+// from an explicit version query. Empty if still unknown.
+func YTDLPVersion() string {
+	if v, ok := ytdlpVersion.Load().(string); ok {
+		return v
+	}
+	return ""
+}
+
+func QueryYTDLPVersion() (string, error) {
+	stdout, err := exec.Command(YTDLPPath, "--version").Output()
+	if err != nil {
+		return "", err
+	}
+
+	version := strings.TrimSpace(string(stdout))
+	ytdlpVersion.Store(version)
+	return version, nil
 }
 
 func UpdateYTDLP() {
@@ -1053,6 +1136,12 @@ func UpdateYTDLP() {
 		"--update",
 	}
 
+	// Wait for in flight extractions to finish
+	ytdlpLock.Lock()
+	defer ytdlpLock.Unlock()
+
+	log.Println("[YTDL_VERSION_BEFORE_UPDATE]:", YTDLPVersion())
+
 	cmd := exec.Command(
 		YTDLPPath,
 		args...,
@@ -1064,6 +1153,7 @@ func UpdateYTDLP() {
 
 	if err := cmd.Start(); err != nil {
 		log.Println("[YTDL_CMD_UPDATE_ERR]:", YTDLPPath, strings.Join(args, " "))
+		return
 	}
 
 	if err := cmd.Wait(); err != nil {
@@ -1072,4 +1162,126 @@ func UpdateYTDLP() {
 				YTDLPPath, strings.Join(args, " "), "code: ", exitErr.ExitCode())
 		}
 	}
+
+	atomic.StoreInt32(&ytdlpFailures, 0)
+
+	version, err := QueryYTDLPVersion()
+	if err != nil {
+		log.Println("[YTDL_VERSION_ERR]:", err)
+		return
+	}
+	log.Println("[YTDL_VERSION_AFTER_UPDATE]:", version)
+}
+
+// Runs yt-dlp self updates every updateEvery and whenever maxFailures
+// extractions fail in a row. A zero value disables the respective trigger.
+func StartYTDLPUpdater(updateEvery time.Duration, maxFailures int) chan struct{} {
+	stop := make(chan struct{})
+	atomic.StoreInt32(&ytdlpMaxFailures, int32(maxFailures))
+
+	if version, err := QueryYTDLPVersion(); err != nil {
+		log.Println("[YTDL_VERSION_ERR]:", err)
+	} else {
+		log.Println("[YTDL_VERSION]:", version)
+	}
+
+	go func() {
+		var tick <-chan time.Time
+		if updateEvery > 0 {
+			ticker := time.NewTicker(updateEvery)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-tick:
+				log.Println("[YTDL_UPDATE]: scheduled update")
+			case <-ytdlpUpdateRequests:
+				log.Printf("[YTDL_UPDATE]: %d extractions failed in a row\n", atomic.LoadInt32(&ytdlpFailures))
+			}
+			UpdateYTDLP()
+		}
+	}()
+
+	return stop
+}
+
+// The id of the youtube video at videoUrl, empty if it doesn't point to one
+func YoutubeVideoID(videoUrl string) string {
+	if !IsYoutubeUrl(videoUrl) {
+		return ""
+	}
+
+	parsed, err := url.Parse(videoUrl)
+	if err != nil {
+		return ""
+	}
+	if id := parsed.Query().Get("v"); id != "" {
+		return id
+	}
+
+	// youtu.be/<id>, youtube.com/shorts/<id> and alike
+	segments := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	return segments[len(segments)-1]
+}
+
+func YoutubeWatchUrl(videoId string) string {
+	return "https://www.youtube.com/watch?v=" + videoId
+}
+
+// A video listed in a playlist, without its media url
+type YoutubeEntry struct {
+	ID       string  `json:"id"`
+	Title    string  `json:"title"`
+	Duration float64 `json:"duration"`
+	Uploader string  `json:"uploader"`
+}
+
+// Up to limit videos youtube would play after videoUrl in its mix
+func YoutubeMix(ctx context.Context, videoUrl string, limit int) ([]YoutubeEntry, error) {
+	videoId := YoutubeVideoID(videoUrl)
+	if videoId == "" {
+		return nil, fmt.Errorf("not a youtube video: %s", videoUrl)
+	}
+
+	args := []string{
+		"--flat-playlist",
+		"--dump-single-json",
+		"--no-warnings",
+		"--playlist-end", strconv.Itoa(limit),
+		YoutubeWatchUrl(videoId) + "&list=RD" + videoId,
+	}
+
+	ytdlpLock.RLock()
+	defer ytdlpLock.RUnlock()
+
+	cmd := exec.CommandContext(
+		ctx,
+		YTDLPPath,
+		args...,
+	)
+
+	if LOG_YTCMD {
+		log.Println("[YTDL_CMD_USED]:", YTDLPPath, strings.Join(args, " "))
+	}
+
+	stdout, err := cmd.Output()
+	if err != nil {
+		reportYTDLPFailure(ctx)
+		return nil, err
+	}
+
+	var playlist struct {
+		Entries []YoutubeEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(stdout, &playlist); err != nil {
+		reportYTDLPFailure(ctx)
+		return nil, err
+	}
+
+	atomic.StoreInt32(&ytdlpFailures, 0)
+	return playlist.Entries, nil
 }